@@ -0,0 +1,213 @@
+// Package admin lets admins control the bot by sending it direct messages on Twitter, e.g. "pause" or "mute <title>".
+// Every command is answered with a direct message that contains the result
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/xarantolus/poliwiki/moderation"
+	"github.com/xarantolus/poliwiki/mute"
+	"github.com/xarantolus/poliwiki/pipeline"
+)
+
+// Admin polls direct messages and executes the commands of admins
+type Admin struct {
+	Client *twitter.Client
+
+	// Admins are the screen names of the accounts that are allowed to send commands.
+	// Messages from everyone else are ignored
+	Admins []string
+
+	// Interval is the time between two polls, defaults to one minute.
+	// Twitter allows 15 requests to the endpoint within 15 minutes
+	Interval time.Duration
+
+	Controls *pipeline.Controls
+
+	// Mutes is changed by "mute" and "unmute"
+	Mutes *mute.List
+
+	// Moderation is the moderation queue for "approve" and "reject", optional
+	Moderation *moderation.Queue
+
+	// Status returns additional lines for the "status" command, optional
+	Status func() string
+
+	// adminIDs maps user IDs of admins to their screen names
+	adminIDs map[string]string
+
+	// lastID is the ID of the newest message we have seen
+	lastID int64
+}
+
+// Run polls direct messages until ctx is cancelled. If the admin accounts can't be looked up,
+// e.g. because Twitter isn't reachable, it tries again with increasing waits in between
+func (a *Admin) Run(ctx context.Context) {
+	var wait = 30 * time.Second
+	for {
+		err := a.lookupAdmins()
+		if err == nil {
+			break
+		}
+		logger.Warn("admin commands are disabled until the admin accounts can be looked up", "err", err, "retry_in", wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > maxLookupWait {
+			wait = maxLookupWait
+		}
+	}
+
+	var interval = a.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	// Commands that were sent before the bot started are not executed again,
+	// so the first poll only finds out which messages we have already seen
+	var initialized bool
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := a.poll(ctx, !initialized)
+		if err != nil {
+			logger.Warn("fetching direct messages failed", "err", err)
+		} else {
+			initialized = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maxLookupWait is the longest time between two tries of looking up the admin accounts
+const maxLookupWait = 30 * time.Minute
+
+// lookupAdmins resolves the screen names of admins to user IDs, as direct messages only contain IDs
+func (a *Admin) lookupAdmins() error {
+	a.adminIDs = make(map[string]string)
+
+	if len(a.Admins) == 0 {
+		return nil
+	}
+
+	users, _, err := a.Client.Users.Lookup(&twitter.UserLookupParams{
+		ScreenName: a.Admins,
+	})
+	if err != nil {
+		return fmt.Errorf("looking up admin accounts: %w", err)
+	}
+
+	for _, u := range users {
+		a.adminIDs[u.IDStr] = u.ScreenName
+	}
+
+	for _, name := range a.Admins {
+		if !a.isAdminName(name) {
+			logger.Warn("admin account doesn't exist", "user", name)
+		}
+	}
+
+	return nil
+}
+
+func (a *Admin) isAdminName(name string) bool {
+	for _, n := range a.adminIDs {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// poll fetches new direct messages and executes them. If skip is true, messages are only marked as seen
+func (a *Admin) poll(ctx context.Context, skip bool) error {
+	list, _, err := a.Client.DirectMessages.EventsList(&twitter.DirectMessageEventsListParams{
+		Count: 50,
+	})
+	if err != nil {
+		return err
+	}
+
+	type message struct {
+		id     int64
+		sender string
+		text   string
+	}
+
+	var messages []message
+	for _, e := range list.Events {
+		if e.Type != "message_create" || e.Message == nil || e.Message.Data == nil {
+			continue
+		}
+
+		id, err := strconv.ParseInt(e.ID, 10, 64)
+		if err != nil || id <= a.lastID {
+			continue
+		}
+
+		messages = append(messages, message{
+			id:     id,
+			sender: e.Message.SenderID,
+			text:   e.Message.Data.Text,
+		})
+	}
+
+	// The API returns the newest message first, but commands should be executed in the order they were sent
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].id < messages[j].id
+	})
+
+	for _, m := range messages {
+		a.lastID = m.id
+
+		if skip {
+			continue
+		}
+
+		name, ok := a.adminIDs[m.sender]
+		if !ok {
+			// This also skips messages the bot sent itself
+			continue
+		}
+
+		log := logger.With("user", name, "dm_id", m.id)
+
+		command, reply := a.execute(ctx, m.text)
+		commandsTotal.With(command).Inc()
+
+		log.Info("executed command", "command", command, "text", m.text)
+
+		_, _, err := a.Client.DirectMessages.EventsNew(&twitter.DirectMessageEventsNewParams{
+			Event: &twitter.DirectMessageEvent{
+				Type: "message_create",
+				Message: &twitter.DirectMessageEventMessage{
+					Target: &twitter.DirectMessageTarget{RecipientID: m.sender},
+					Data:   &twitter.DirectMessageData{Text: reply},
+				},
+			},
+		})
+		if err != nil {
+			log.Error("replying to command failed", "err", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xarantolus/poliwiki/moderation"
+	"github.com/xarantolus/poliwiki/mute"
+	"github.com/xarantolus/poliwiki/pipeline"
+)
+
+const help = `Befehle:
+pause – nichts mehr posten
+resume – wieder posten
+mute [Dauer] <Ziel> – nichts mehr zu diesem Ziel posten, z.B. "mute 7d Max Mustermann". Ziele sind Artikel, WikiData-IDs (Q567), Benutzer (user:Name) und IP-Bereiche (192.0.2.0/24)
+unmute [Ziel] – Ziel wieder posten, ohne Ziel alle
+status – aktueller Zustand
+retweet <ID oder Link> – Tweet retweeten
+approve <ID> [Text] – Post aus der Moderation freigeben, optional mit anderem Text
+reject <ID> – Post aus der Moderation verwerfen`
+
+// execute runs the command in text. command is the name of the command for logs and metrics
+func (a *Admin) execute(ctx context.Context, text string) (command string, reply string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "unknown", help
+	}
+
+	command = strings.ToLower(fields[0])
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), fields[0]))
+
+	switch command {
+	case "help", "hilfe":
+		return command, help
+	case "pause":
+		a.Controls.Pause()
+		return command, "Pausiert, es wird nichts mehr gepostet. Mit \"resume\" geht es weiter."
+	case "resume":
+		a.Controls.Resume()
+		return command, "Es wird wieder gepostet."
+	case "mute":
+		return command, a.mute(args)
+	case "unmute":
+		return command, a.unmute(args)
+	case "status":
+		return command, a.status()
+	case "retweet":
+		return command, a.retweet(args)
+	case "approve":
+		return command, a.approve(ctx, args)
+	case "reject":
+		return command, a.reject(args)
+	default:
+		return "unknown", fmt.Sprintf("Unbekannter Befehl %q.\n\n%s", fields[0], help)
+	}
+}
+
+func (a *Admin) status() string {
+	var lines []string
+
+	if a.Controls.Paused() {
+		lines = append(lines, "Pausiert")
+	} else {
+		lines = append(lines, "Läuft")
+	}
+
+	if entries := a.Mutes.Entries(); len(entries) > 0 {
+		var muted []string
+		for _, e := range entries {
+			muted = append(muted, e.String())
+		}
+		lines = append(lines, "Stummgeschaltet: "+strings.Join(muted, ", "))
+	}
+
+	if a.Moderation != nil {
+		lines = append(lines, fmt.Sprintf("Warten auf Moderation: %d", len(a.Moderation.Pending())))
+	}
+
+	if a.Status != nil {
+		lines = append(lines, a.Status())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (a *Admin) mute(args string) string {
+	var until time.Time
+
+	// The first word is a duration if it can be parsed as one
+	first, rest := splitFirst(args)
+	if d, err := parseDuration(first); err == nil && rest != "" {
+		until = time.Now().Add(d)
+		args = rest
+	}
+
+	e, err := mute.Parse(args)
+	if err != nil {
+		return "Was soll stummgeschaltet werden? z.B. \"mute Max Mustermann\" oder \"mute 7d user:Name\""
+	}
+	e.Until = until
+	e.Reason = "admin command"
+
+	err = a.Mutes.Add(e)
+	if err != nil {
+		return "Stummschalten fehlgeschlagen: " + err.Error()
+	}
+
+	return e.String() + " ist stummgeschaltet."
+}
+
+func (a *Admin) unmute(args string) string {
+	if args == "" {
+		err := a.Mutes.Clear()
+		if err != nil {
+			return "Freigeben fehlgeschlagen: " + err.Error()
+		}
+		return "Alles ist wieder freigegeben, außer den Einträgen aus der Konfiguration."
+	}
+
+	e, err := mute.Parse(args)
+	if err != nil {
+		return "Was soll freigegeben werden? z.B. \"unmute Max Mustermann\""
+	}
+
+	ok, err := a.Mutes.Remove(e)
+	if err != nil {
+		return "Freigeben fehlgeschlagen: " + err.Error()
+	}
+	if !ok {
+		return e.String() + " war nicht per DM stummgeschaltet."
+	}
+
+	return e.String() + " ist wieder freigegeben."
+}
+
+// parseDuration is like time.ParseDuration, but also supports days, e.g. "7d"
+func parseDuration(s string) (d time.Duration, err error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err = time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("invalid duration %q", s)
+	}
+	return
+}
+
+func (a *Admin) retweet(arg string) string {
+	id, ok := parseTweetID(arg)
+	if !ok {
+		return "Welcher Tweet? z.B. \"retweet 1234567890\" oder ein Link"
+	}
+
+	_, _, err := a.Client.Statuses.Retweet(id, nil)
+	if err != nil {
+		return "Retweeten fehlgeschlagen: " + err.Error()
+	}
+
+	return "Retweetet."
+}
+
+// parseTweetID parses a tweet ID or a link to a tweet, e.g. https://twitter.com/user/status/123
+func parseTweetID(s string) (id int64, ok bool) {
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = path.Base(u.Path)
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	return id, err == nil && id > 0
+}
+
+func (a *Admin) approve(ctx context.Context, args string) string {
+	if a.Moderation == nil {
+		return "Die Moderation ist nicht aktiviert."
+	}
+
+	idStr, text := splitFirst(args)
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "Welcher Post? z.B. \"approve 12\" oder \"approve 12 Neuer Text\""
+	}
+
+	pub, err := a.Moderation.Approve(ctx, id, text, "")
+	if errors.Is(err, pipeline.ErrDeferred) {
+		return "Freigegeben, wird wegen des Limits später gepostet."
+	}
+	if err != nil {
+		return moderationError(id, err)
+	}
+
+	return "Gepostet: " + pub.URL
+}
+
+func (a *Admin) reject(args string) string {
+	if a.Moderation == nil {
+		return "Die Moderation ist nicht aktiviert."
+	}
+
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return "Welcher Post? z.B. \"reject 12\""
+	}
+
+	err = a.Moderation.Reject(id)
+	if err != nil {
+		return moderationError(id, err)
+	}
+
+	return fmt.Sprintf("Post %d verworfen.", id)
+}
+
+func moderationError(id int, err error) string {
+	if errors.Is(err, moderation.ErrNotFound) {
+		return fmt.Sprintf("Es gibt keinen Post %d, der auf Moderation wartet.", id)
+	}
+	return "Posten fehlgeschlagen: " + err.Error()
+}
+
+// splitFirst returns the first word of s and the rest
+func splitFirst(s string) (first, rest string) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexAny(s, " \t\n")
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimSpace(s[i:])
+}
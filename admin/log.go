@@ -0,0 +1,5 @@
+package admin
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "admin")
@@ -0,0 +1,5 @@
+package admin
+
+import "github.com/xarantolus/poliwiki/metrics"
+
+var commandsTotal = metrics.NewCounterVec("poliwiki_admin_commands_total", "Commands received from admins via direct message", "command")
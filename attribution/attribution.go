@@ -0,0 +1,127 @@
+package attribution
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/xarantolus/poliwiki/config"
+)
+
+// Attribution describes where an edit came from
+type Attribution struct {
+	// Source is the name of the network or the configured text of an account.
+	// It never contains the user name of a registered user
+	Source string
+
+	// Text should be added to the tweet, e.g. "Änderung aus dem Netz des Bundestags"
+	Text string
+
+	// Anonymous is true if the edit was made by an IP address
+	Anonymous bool
+}
+
+type network struct {
+	name, text string
+	ranges     []*net.IPNet
+
+	// party and level are used for detecting conflicts of interest, both could be empty
+	party, level string
+}
+
+// Analyzer checks who made an edit
+type Analyzer struct {
+	networks []network
+
+	// accounts maps the normalized user name to the text that should be posted
+	accounts map[string]string
+}
+
+// New returns an analyzer for the networks and accounts in the configuration
+func New(cfg config.Config) (a *Analyzer, err error) {
+	a = &Analyzer{
+		accounts: make(map[string]string),
+	}
+
+	for _, n := range cfg.Attribution.Networks {
+		var nw = network{
+			name:  n.Name,
+			text:  n.Text,
+			party: n.Party,
+			level: n.Level,
+		}
+		if nw.text == "" {
+			nw.text = "Änderung aus dem Netz von " + n.Name
+		}
+
+		for _, r := range n.Ranges {
+			_, ipnet, err := net.ParseCIDR(r)
+			if err != nil {
+				return nil, fmt.Errorf("parsing range of network %q: %w", n.Name, err)
+			}
+			nw.ranges = append(nw.ranges, ipnet)
+		}
+
+		a.networks = append(a.networks, nw)
+	}
+
+	for _, acc := range cfg.Attribution.Accounts {
+		text := acc.Text
+		if text == "" {
+			text = "Änderung durch ein beobachtetes Konto"
+		}
+		a.accounts[normalizeUser(acc.User)] = text
+	}
+
+	return
+}
+
+// Analyze returns where an edit by the given user came from. ok is false
+// if the user is not in any of the configured networks or accounts
+func (a *Analyzer) Analyze(user string) (attr Attribution, ok bool) {
+	if ip := net.ParseIP(user); ip != nil {
+		n, ok := a.network(ip)
+		if !ok {
+			return attr, false
+		}
+
+		return Attribution{
+			Source:    n.name,
+			Text:      n.text,
+			Anonymous: true,
+		}, true
+	}
+
+	text, ok := a.accounts[normalizeUser(user)]
+	if !ok {
+		return
+	}
+
+	return Attribution{
+		Source: text,
+		Text:   text,
+	}, true
+}
+
+// network returns the first configured network that contains ip
+func (a *Analyzer) network(ip net.IP) (n network, ok bool) {
+	for _, n := range a.networks {
+		for _, r := range n.ranges {
+			if r.Contains(ip) {
+				return n, true
+			}
+		}
+	}
+	return
+}
+
+// IsAnonymous returns whether the user name of an edit is an IP address
+func IsAnonymous(user string) bool {
+	return net.ParseIP(user) != nil
+}
+
+// normalizeUser makes user names comparable. MediaWiki treats underscores like spaces
+// and the first letter is always upper case, so we just compare them case-insensitively
+func normalizeUser(user string) string {
+	return strings.ToLower(strings.TrimSpace(strings.ReplaceAll(user, "_", " ")))
+}
@@ -0,0 +1,181 @@
+package attribution
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"unicode"
+
+	"github.com/xarantolus/poliwiki/wikidata"
+)
+
+// Conflict describes how likely it is that an edit was made by the politician themselves or someone working for them
+type Conflict struct {
+	// Score is between 0 (no indication) and 1 (very likely)
+	Score float64
+
+	// Reasons explains the score, e.g. for logs. It never contains the user name
+	Reasons []string
+}
+
+// Text returns a text for the tweet, e.g. "Mögliche Eigenbearbeitung (Wahrscheinlichkeit 80 %)"
+func (c Conflict) Text() string {
+	return fmt.Sprintf("Mögliche Eigenbearbeitung (Wahrscheinlichkeit %d %%)", int(math.Round(c.Score*100)))
+}
+
+// add combines the score of a new indication with the existing score, as if they were independent
+func (c *Conflict) add(score float64, reason string) {
+	c.Score = 1 - (1-c.Score)*(1-score)
+	c.Reasons = append(c.Reasons, reason)
+}
+
+// Words in user names that hint at an office or staff of a politician
+var officeWords = []string{"buero", "team", "mdb", "mdl", "mdep", "abgeordnete", "abgeordneter", "wahlkreis", "fraktion", "presse"}
+
+// Conflict returns how likely it is that the given user is the politician, their office or an institution of their party
+func (a *Analyzer) Conflict(user string, poli wikidata.Politician) (c Conflict) {
+	if ip := net.ParseIP(user); ip != nil {
+		n, ok := a.network(ip)
+		if !ok {
+			return
+		}
+
+		if party, ok := poli.CurrentParty(); ok && n.party != "" &&
+			equalsAny(n.party, party.ID, party.Name, party.ShortName, party.Hashtag) {
+			c.add(0.6, "IP aus dem Netz einer Institution der eigenen Partei")
+		}
+
+		if n.level != "" && poli.HasLevel(wikidata.Level(n.level)) {
+			c.add(0.3, "IP aus dem Netz des eigenen Parlaments")
+		}
+
+		return
+	}
+
+	var (
+		tokens = nameTokens(user)
+		joined = strings.Join(tokens, "")
+
+		first = strings.Join(nameTokens(poli.FirstName), "")
+		last  = strings.Join(nameTokens(poli.LastName), "")
+	)
+	if len(tokens) == 0 || last == "" {
+		return
+	}
+
+	switch {
+	case first != "" && (strings.Contains(joined, first+last) || strings.Contains(joined, last+first)):
+		c.add(0.8, "Benutzername enthält den vollständigen Namen")
+	case first != "" && strings.Contains(joined, string([]rune(first)[:1])+last):
+		c.add(0.7, "Benutzername enthält Initiale und Nachnamen")
+	case containsSimilar(tokens, last):
+		c.add(0.5, "Benutzername ähnelt dem Nachnamen")
+	default:
+		// An office word without a name isn't really an indication
+		return
+	}
+
+	var offices = append([]string{}, officeWords...)
+	for _, pos := range poli.Positions {
+		if pos.ShortName != "" {
+			offices = append(offices, strings.Join(nameTokens(pos.ShortName), ""))
+		}
+	}
+
+	for _, w := range offices {
+		if w != "" && strings.Contains(joined, w) {
+			c.add(0.2, "Benutzername deutet auf ein Amt oder Büro hin")
+			break
+		}
+	}
+
+	return
+}
+
+// containsSimilar returns whether any of the tokens is equal to word or only differs by one
+// character. The latter is only allowed for longer words to avoid false positives
+func containsSimilar(tokens []string, word string) bool {
+	for _, t := range tokens {
+		if t == word || (len(word) >= 5 && editDistanceAtMostOne(t, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistanceAtMostOne returns whether a can be turned into b with at most one insertion, deletion or substitution
+func editDistanceAtMostOne(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+
+	var i, j, edits int
+	for i < len(ra) && j < len(rb) {
+		if ra[i] == rb[j] {
+			i++
+			j++
+			continue
+		}
+
+		edits++
+		if edits > 1 {
+			return false
+		}
+
+		if len(ra) == len(rb) {
+			i++
+		}
+		j++
+	}
+
+	return edits+(len(rb)-j)+(len(ra)-i) <= 1
+}
+
+var umlautReplacer = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+
+// nameTokens lowercases the text, replaces umlauts and splits it into words.
+// Splitting also happens at case changes, so "MaxMustermann" becomes "max" and "mustermann"
+func nameTokens(text string) (tokens []string) {
+	var (
+		cur  []rune
+		prev rune
+	)
+
+	flush := func() {
+		if len(cur) > 0 {
+			tokens = append(tokens, umlautReplacer.Replace(strings.ToLower(string(cur))))
+			cur = cur[:0]
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case !unicode.IsLetter(r):
+			flush()
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			flush()
+			cur = append(cur, r)
+		default:
+			cur = append(cur, r)
+		}
+		prev = r
+	}
+	flush()
+
+	return
+}
+
+func equalsAny(s string, values ...string) bool {
+	s = strings.TrimPrefix(s, "#")
+	for _, v := range values {
+		if v != "" && strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
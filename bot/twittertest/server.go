@@ -0,0 +1,588 @@
+// Package twittertest provides an in-memory fake of the parts of the Twitter v1.1 API the bot uses.
+// It records posted tweets and uploaded media and can return injected errors like rate limits
+package twittertest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// Endpoints of the fake server, used for injecting errors
+const (
+	EndpointVerifyCredentials = "account/verify_credentials"
+	EndpointUpload            = "media/upload"
+	EndpointUpdate            = "statuses/update"
+	EndpointRetweet           = "statuses/retweet"
+	EndpointUsersLookup       = "users/lookup"
+	EndpointListDMs           = "direct_messages/events/list"
+	EndpointSendDM            = "direct_messages/events/new"
+	EndpointRateLimits        = "application/rate_limit_status"
+)
+
+// Error codes returned by Twitter, see https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+const (
+	CodeRateLimitExceeded = 88
+	CodeDuplicateStatus   = 187
+	CodeStatusTooLong     = 186
+	CodeAlreadyRetweeted  = 327
+)
+
+// Tweet is a tweet that was posted to the server
+type Tweet struct {
+	ID                int64
+	Text              string
+	InReplyToStatusID int64
+	MediaIDs          []int64
+	Time              time.Time
+}
+
+// Media is an uploaded piece of media
+type Media struct {
+	ID         int64
+	MediaType  string
+	TotalBytes int
+	Data       []byte
+	Finalized  bool
+}
+
+// DirectMessage is a direct message that was sent to or by the user
+type DirectMessage struct {
+	ID          string
+	SenderID    string
+	RecipientID string
+	Text        string
+	Time        time.Time
+}
+
+// Error is an error the server returns instead of handling a request
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+// RateLimitError is returned by Twitter when too many requests were made
+var RateLimitError = Error{Status: http.StatusTooManyRequests, Code: CodeRateLimitExceeded, Message: "Rate limit exceeded"}
+
+// Server is a fake Twitter API server
+type Server struct {
+	*httptest.Server
+
+	// User is returned when verifying credentials
+	User twitter.User
+
+	mu       sync.Mutex
+	nextID   int64
+	tweets   []Tweet
+	media    map[int64]*Media
+	errors   map[string][]Error
+	users    []twitter.User
+	dms      []DirectMessage
+	retweets []int64
+	limits   map[string]*twitter.RateLimitResource
+}
+
+// NewServer starts a fake server for the user with the given screen name
+func NewServer(screenName string) *Server {
+	s := &Server{
+		User: twitter.User{
+			ID:         1,
+			IDStr:      "1",
+			ScreenName: screenName,
+			Name:       screenName,
+		},
+		nextID: 1000,
+		media:  make(map[int64]*Media),
+		errors: make(map[string][]Error),
+		limits: make(map[string]*twitter.RateLimitResource),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1.1/account/verify_credentials.json", s.handle(EndpointVerifyCredentials, s.verifyCredentials))
+	mux.HandleFunc("/1.1/media/upload.json", s.handle(EndpointUpload, s.upload))
+	mux.HandleFunc("/1.1/statuses/update.json", s.handle(EndpointUpdate, s.update))
+	mux.HandleFunc("/1.1/statuses/retweet/", s.handle(EndpointRetweet, s.retweet))
+	mux.HandleFunc("/1.1/users/lookup.json", s.handle(EndpointUsersLookup, s.usersLookup))
+	mux.HandleFunc("/1.1/direct_messages/events/list.json", s.handle(EndpointListDMs, s.listDMs))
+	mux.HandleFunc("/1.1/direct_messages/events/new.json", s.handle(EndpointSendDM, s.sendDM))
+	mux.HandleFunc("/1.1/application/rate_limit_status.json", s.handle(EndpointRateLimits, s.rateLimits))
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Client returns a twitter client that sends all requests to this server instead of Twitter
+func (s *Server) Client() *twitter.Client {
+	target, _ := url.Parse(s.URL)
+
+	return twitter.NewClient(&http.Client{
+		Transport: &rewriteTransport{
+			target: target,
+			next:   s.Server.Client().Transport,
+		},
+	})
+}
+
+// FailNext makes the next request to the endpoint fail with the given error.
+// Calling it multiple times queues the errors
+func (s *Server) FailNext(endpoint string, e Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[endpoint] = append(s.errors[endpoint], e)
+}
+
+// Tweets returns all tweets that were posted, oldest first
+func (s *Server) Tweets() []Tweet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Tweet(nil), s.tweets...)
+}
+
+// Media returns the uploaded media with the given ID
+func (s *Server) Media(id int64) (m Media, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mp, ok := s.media[id]
+	if !ok {
+		return
+	}
+	return *mp, true
+}
+
+// Thread returns the tweet with the given ID and all tweets that are replies to it or to one of its replies
+func (s *Server) Thread(id int64) (thread []Tweet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids = map[int64]bool{id: true}
+	for _, t := range s.tweets {
+		if ids[t.ID] || ids[t.InReplyToStatusID] {
+			ids[t.ID] = true
+			thread = append(thread, t)
+		}
+	}
+
+	return
+}
+
+// AddUser adds another user, e.g. one that sends direct messages to the bot
+func (s *Server) AddUser(screenName string) twitter.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	u := twitter.User{
+		ID:         s.nextID,
+		IDStr:      strconv.FormatInt(s.nextID, 10),
+		ScreenName: screenName,
+		Name:       screenName,
+	}
+	s.users = append(s.users, u)
+
+	return u
+}
+
+// ReceiveDM adds a direct message from the given user to the bot
+func (s *Server) ReceiveDM(from twitter.User, text string) DirectMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addDM(from.IDStr, s.User.IDStr, text)
+}
+
+// DirectMessages returns all direct messages that were sent or received, oldest first
+func (s *Server) DirectMessages() []DirectMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]DirectMessage(nil), s.dms...)
+}
+
+// Retweets returns the IDs of all tweets that were retweeted
+func (s *Server) Retweets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int64(nil), s.retweets...)
+}
+
+// SetRateLimit sets the rate limit that is reported for a statuses endpoint, e.g. "/statuses/update"
+func (s *Server) SetRateLimit(endpoint string, limit, remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits[endpoint] = &twitter.RateLimitResource{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     int(reset.Unix()),
+	}
+}
+
+// addDM stores a direct message. The lock must be held
+func (s *Server) addDM(senderID, recipientID, text string) DirectMessage {
+	s.nextID++
+	dm := DirectMessage{
+		ID:          strconv.FormatInt(s.nextID, 10),
+		SenderID:    senderID,
+		RecipientID: recipientID,
+		Text:        text,
+		Time:        time.Now(),
+	}
+	s.dms = append(s.dms, dm)
+
+	return dm
+}
+
+// handle returns a handler that returns injected errors before calling f
+func (s *Server) handle(endpoint string, f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		var (
+			e      Error
+			failed = len(s.errors[endpoint]) > 0
+		)
+		if failed {
+			e = s.errors[endpoint][0]
+			s.errors[endpoint] = s.errors[endpoint][1:]
+		}
+		s.mu.Unlock()
+
+		if failed {
+			writeError(w, e)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			writeError(w, Error{Status: http.StatusBadRequest, Code: 44, Message: err.Error()})
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+func (s *Server) verifyCredentials(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.User)
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, Error{Status: http.StatusMethodNotAllowed, Code: 34, Message: "Sorry, that page does not exist"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.PostForm.Get("command") {
+	case "INIT":
+		total, err := strconv.Atoi(r.PostForm.Get("total_bytes"))
+		if err != nil {
+			writeError(w, Error{Status: http.StatusBadRequest, Code: 38, Message: "total_bytes parameter is missing"})
+			return
+		}
+
+		s.nextID++
+		m := &Media{
+			ID:         s.nextID,
+			MediaType:  r.PostForm.Get("media_type"),
+			TotalBytes: total,
+		}
+		s.media[m.ID] = m
+
+		writeJSON(w, map[string]interface{}{
+			"media_id":           m.ID,
+			"media_id_string":    strconv.FormatInt(m.ID, 10),
+			"expires_after_secs": 86400,
+		})
+	case "APPEND":
+		m, ok := s.mediaFromForm(w, r)
+		if !ok {
+			return
+		}
+
+		chunk, err := base64.StdEncoding.DecodeString(r.PostForm.Get("media_data"))
+		if err != nil {
+			writeError(w, Error{Status: http.StatusBadRequest, Code: 324, Message: "Invalid media data"})
+			return
+		}
+		m.Data = append(m.Data, chunk...)
+
+		w.WriteHeader(http.StatusNoContent)
+	case "FINALIZE":
+		m, ok := s.mediaFromForm(w, r)
+		if !ok {
+			return
+		}
+
+		if len(m.Data) != m.TotalBytes {
+			writeError(w, Error{Status: http.StatusBadRequest, Code: 324, Message: fmt.Sprintf("File size mismatch: got %d bytes, expected %d", len(m.Data), m.TotalBytes)})
+			return
+		}
+		m.Finalized = true
+
+		writeJSON(w, map[string]interface{}{
+			"media_id":           m.ID,
+			"media_id_string":    strconv.FormatInt(m.ID, 10),
+			"size":               len(m.Data),
+			"expires_after_secs": 86400,
+		})
+	default:
+		writeError(w, Error{Status: http.StatusBadRequest, Code: 38, Message: "command parameter is missing"})
+	}
+}
+
+// mediaFromForm returns the media of the media_id parameter. The lock must be held
+func (s *Server) mediaFromForm(w http.ResponseWriter, r *http.Request) (m *Media, ok bool) {
+	id, err := strconv.ParseInt(r.PostForm.Get("media_id"), 10, 64)
+	if err == nil {
+		m, ok = s.media[id]
+	}
+	if !ok {
+		writeError(w, Error{Status: http.StatusBadRequest, Code: 324, Message: "Invalid media_id"})
+	}
+	return
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, Error{Status: http.StatusMethodNotAllowed, Code: 34, Message: "Sorry, that page does not exist"})
+		return
+	}
+
+	var t = Tweet{
+		Text: r.PostForm.Get("status"),
+		Time: time.Now(),
+	}
+
+	if t.Text == "" && r.PostForm.Get("media_ids") == "" {
+		writeError(w, Error{Status: http.StatusForbidden, Code: 170, Message: "Missing required parameter: status."})
+		return
+	}
+
+	// Links are shortened to 23 characters by Twitter, we don't do that. So tweets with links are allowed
+	// to be a bit longer than 280 characters here, but it still catches most texts that are way too long
+	if len([]rune(t.Text)) > 280+23*strings.Count(t.Text, "https://") {
+		writeError(w, Error{Status: http.StatusForbidden, Code: CodeStatusTooLong, Message: "Tweet needs to be a bit shorter."})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reply := r.PostForm.Get("in_reply_to_status_id"); reply != "" {
+		id, err := strconv.ParseInt(reply, 10, 64)
+		if err != nil || !s.hasTweet(id) {
+			writeError(w, Error{Status: http.StatusBadRequest, Code: 385, Message: "You attempted to reply to a Tweet that is deleted or not visible to you."})
+			return
+		}
+		t.InReplyToStatusID = id
+	}
+
+	if ids := r.PostForm.Get("media_ids"); ids != "" {
+		for _, idStr := range strings.Split(ids, ",") {
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			m, ok := s.media[id]
+			if err != nil || !ok || !m.Finalized {
+				writeError(w, Error{Status: http.StatusBadRequest, Code: 324, Message: "Invalid media_ids"})
+				return
+			}
+			t.MediaIDs = append(t.MediaIDs, id)
+		}
+	}
+
+	// Twitter doesn't allow posting the same text twice in a row
+	if len(s.tweets) > 0 && s.tweets[len(s.tweets)-1].Text == t.Text {
+		writeError(w, Error{Status: http.StatusForbidden, Code: CodeDuplicateStatus, Message: "Status is a duplicate."})
+		return
+	}
+
+	s.nextID++
+	t.ID = s.nextID
+	s.tweets = append(s.tweets, t)
+
+	user := s.User
+	writeJSON(w, twitter.Tweet{
+		ID:                   t.ID,
+		IDStr:                strconv.FormatInt(t.ID, 10),
+		Text:                 t.Text,
+		FullText:             t.Text,
+		CreatedAt:            t.Time.Format(time.RubyDate),
+		InReplyToStatusID:    t.InReplyToStatusID,
+		InReplyToStatusIDStr: strconv.FormatInt(t.InReplyToStatusID, 10),
+		User:                 &user,
+	})
+}
+
+func (s *Server) retweet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, Error{Status: http.StatusMethodNotAllowed, Code: 34, Message: "Sorry, that page does not exist"})
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1.1/statuses/retweet/"), ".json"), 10, 64)
+	if err != nil {
+		writeError(w, Error{Status: http.StatusNotFound, Code: 144, Message: "No status found with that ID."})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rt := range s.retweets {
+		if rt == id {
+			writeError(w, Error{Status: http.StatusForbidden, Code: CodeAlreadyRetweeted, Message: "You have already retweeted this Tweet."})
+			return
+		}
+	}
+	s.retweets = append(s.retweets, id)
+
+	writeJSON(w, twitter.Tweet{
+		ID:    id,
+		IDStr: strconv.FormatInt(id, 10),
+	})
+}
+
+func (s *Server) usersLookup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found = []twitter.User{}
+	for _, name := range strings.Split(r.Form.Get("screen_name"), ",") {
+		for _, u := range append([]twitter.User{s.User}, s.users...) {
+			if strings.EqualFold(u.ScreenName, name) {
+				found = append(found, u)
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		writeError(w, Error{Status: http.StatusNotFound, Code: 17, Message: "No user matches for specified terms."})
+		return
+	}
+
+	writeJSON(w, found)
+}
+
+func (s *Server) listDMs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events = twitter.DirectMessageEvents{Events: []twitter.DirectMessageEvent{}}
+
+	// Newest first, like Twitter
+	for i := len(s.dms) - 1; i >= 0; i-- {
+		events.Events = append(events.Events, dmEvent(s.dms[i]))
+	}
+
+	writeJSON(w, events)
+}
+
+func (s *Server) sendDM(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, Error{Status: http.StatusMethodNotAllowed, Code: 34, Message: "Sorry, that page does not exist"})
+		return
+	}
+
+	var params twitter.DirectMessageEventsNewParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Event == nil || params.Event.Message == nil ||
+		params.Event.Message.Target == nil || params.Event.Message.Data == nil {
+		writeError(w, Error{Status: http.StatusBadRequest, Code: 214, Message: "event.message_create is missing."})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dm := s.addDM(s.User.IDStr, params.Event.Message.Target.RecipientID, params.Event.Message.Data.Text)
+
+	writeJSON(w, map[string]interface{}{
+		"event": dmEvent(dm),
+	})
+}
+
+func (s *Server) rateLimits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var statuses = make(map[string]*twitter.RateLimitResource)
+	for endpoint, l := range s.limits {
+		statuses[endpoint] = l
+	}
+
+	writeJSON(w, twitter.RateLimit{
+		Resources: &twitter.RateLimitResources{Statuses: statuses},
+	})
+}
+
+func dmEvent(dm DirectMessage) twitter.DirectMessageEvent {
+	return twitter.DirectMessageEvent{
+		CreatedAt: strconv.FormatInt(dm.Time.UnixNano()/int64(time.Millisecond), 10),
+		ID:        dm.ID,
+		Type:      "message_create",
+		Message: &twitter.DirectMessageEventMessage{
+			SenderID: dm.SenderID,
+			Target:   &twitter.DirectMessageTarget{RecipientID: dm.RecipientID},
+			Data:     &twitter.DirectMessageData{Text: dm.Text},
+		},
+	}
+}
+
+// hasTweet returns whether a tweet with the given ID was posted. The lock must be held
+func (s *Server) hasTweet(id int64) bool {
+	for _, t := range s.tweets {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, e Error) {
+	w.Header().Set("Content-Type", "application/json")
+	if e.Status == http.StatusTooManyRequests {
+		w.Header().Set("x-rate-limit-remaining", "0")
+		w.Header().Set("x-rate-limit-reset", strconv.FormatInt(time.Now().Add(15*time.Minute).Unix(), 10))
+	}
+	w.WriteHeader(e.Status)
+
+	_ = json.NewEncoder(w).Encode(twitter.APIError{
+		Errors: []twitter.ErrorDetail{{Code: e.Code, Message: e.Message}},
+	})
+}
+
+// rewriteTransport sends all requests to the target, no matter which host they were meant for
+type rewriteTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+
+	return t.next.RoundTrip(req)
+}
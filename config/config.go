@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,6 +14,210 @@ type Config struct {
 		APIKey            string `yaml:"api_key"`
 		APISecretKey      string `yaml:"api_secret"`
 	} `yaml:"twitter"`
+
+	Log struct {
+		// Level is the minimum level of lines that are written: "debug", "info" (default), "warn" or "error"
+		Level string `yaml:"level"`
+		// Format is "logfmt" (default) or "json"
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+
+	// HTTP serves metrics for Prometheus on /metrics, health checks on /healthz and /readyz and
+	// a dashboard of recent decisions on /dashboard/. It's disabled if no address is set
+	HTTP struct {
+		// Address to listen on, e.g. ":8080" or "127.0.0.1:8080"
+		Address string `yaml:"address"`
+
+		// MaxEventAge is the time after which /healthz fails if no event was received from the stream. Defaults to 10 minutes
+		MaxEventAge time.Duration `yaml:"max_event_age"`
+		// MaxRenderFailures is the number of screenshots in a row that must fail before /healthz fails. Defaults to 5
+		MaxRenderFailures int `yaml:"max_render_failures"`
+
+		// DashboardSize is the number of recent decisions shown on /dashboard/. Defaults to 50
+		DashboardSize int `yaml:"dashboard_size"`
+	} `yaml:"http"`
+
+	Stream struct {
+		// Types are the types of recentchange events that are posted about: "edit", "new", "log" (deletions
+		// and protections) and "categorize". Defaults to only "edit"
+		Types []string `yaml:"types"`
+
+		// Overflow is what happens if events arrive faster than they are processed: "block" (default)
+		// stops reading from the stream, "drop_oldest" drops the oldest waiting event and "spill" writes
+		// events to a file until they can be processed
+		Overflow string `yaml:"overflow"`
+		// SpillFile is the file used by "spill". Defaults to a file in the temp directory
+		SpillFile string `yaml:"spill_file"`
+	} `yaml:"stream"`
+
+	// Workers process events of different articles at the same time. Events of the same article are processed in order
+	Workers struct {
+		// Count is the number of workers. Defaults to 4
+		Count int `yaml:"count"`
+		// QueueSize is the number of events that can wait for each worker. Defaults to 25
+		QueueSize int `yaml:"queue_size"`
+		// DropWhenFull drops events if a queue is full instead of waiting until there's space again
+		DropWhenFull bool `yaml:"drop_when_full"`
+	} `yaml:"workers"`
+
+	// EditWar posts alerts when articles see many reverts in a short time or get protected
+	EditWar struct {
+		Enabled bool `yaml:"enabled"`
+		// Window is how far back edits are considered, e.g. "1h". Defaults to one hour
+		Window time.Duration `yaml:"window"`
+		// MinEdits and MinReverts must both be reached within the window. Default to 6 and 3
+		MinEdits   int `yaml:"min_edits"`
+		MinReverts int `yaml:"min_reverts"`
+	} `yaml:"edit_war"`
+
+	// TalkPages also posts about the talk pages ("Diskussion:") of articles
+	TalkPages struct {
+		Enabled bool `yaml:"enabled"`
+		// MinSize is the minimum size change of edits that don't add a new section. Defaults to 500
+		MinSize int `yaml:"min_size"`
+		// MinInterval is the minimum time between two tweets about the same talk page. Defaults to 6 hours
+		MinInterval time.Duration `yaml:"min_interval"`
+		// MaxPerHour is the maximum number of tweets about talk pages per hour. Defaults to 4
+		MaxPerHour int `yaml:"max_per_hour"`
+	} `yaml:"talk_pages"`
+
+	// RateLimit limits how many posts are published, all of them and per article. Both are token buckets:
+	// up to Burst posts can be published at once, then PerHour posts per hour
+	RateLimit struct {
+		Global struct {
+			// PerHour defaults to 30, Burst to 5
+			PerHour float64 `yaml:"per_hour"`
+			Burst   int     `yaml:"burst"`
+		} `yaml:"global"`
+		PerArticle struct {
+			// PerHour defaults to 3, Burst to 2
+			PerHour float64 `yaml:"per_hour"`
+			Burst   int     `yaml:"burst"`
+		} `yaml:"per_article"`
+
+		// Overflow is what happens to posts that exceed a limit: "drop", "queue" (default) posts them
+		// once the limit allows it and "digest" collects them in a summary that is posted every DigestInterval
+		Overflow string `yaml:"overflow"`
+		// QueueSize is the maximum number of queued posts, more are dropped. Defaults to 50
+		QueueSize int `yaml:"queue_size"`
+		// DigestInterval defaults to one hour
+		DigestInterval time.Duration `yaml:"digest_interval"`
+
+		// TwitterCheckInterval is how often Twitter is asked about its own rate limits. Defaults to 15 minutes
+		TwitterCheckInterval time.Duration `yaml:"twitter_check_interval"`
+	} `yaml:"rate_limit"`
+
+	// Digest posts summaries of the most edited articles about politicians as threads
+	Digest struct {
+		// Daily posts a digest of the last 24 hours every day
+		Daily bool `yaml:"daily"`
+		// Weekly posts a digest of the last seven days on Weekday, e.g. "sunday" (default)
+		Weekly  bool   `yaml:"weekly"`
+		Weekday string `yaml:"weekday"`
+
+		// Time is the local time of day the digests are posted, defaults to "20:00"
+		Time string `yaml:"time"`
+
+		// Top is the number of articles in a digest. Defaults to 5
+		Top int `yaml:"top"`
+		// Chart adds a bar chart of the top articles to the first post
+		Chart bool `yaml:"chart"`
+	} `yaml:"digest"`
+
+	// Stats keeps every matched event and what happened to it, it can be queried on /stats or with -stats
+	Stats struct {
+		// File is the file the events are appended to. Nothing is kept if it's empty
+		File string `yaml:"file"`
+
+		// UserKey is a secret that is used for storing pseudonyms of editors instead of their names and IP addresses.
+		// Without it, editors are not stored at all. Changing it means edits before and after can't be grouped by editor
+		UserKey string `yaml:"user_key"`
+	} `yaml:"stats"`
+
+	// Moderation holds back posts until a moderator approves them on /moderation/
+	Moderation struct {
+		Enabled bool `yaml:"enabled"`
+
+		// User and Password protect the moderation page with HTTP basic auth. Both are required
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+
+		// MaxAge is the time after which posts that weren't moderated are dropped. Defaults to one day
+		MaxAge time.Duration `yaml:"max_age"`
+
+		// AutoApprove describes low-risk posts that are published without moderation.
+		// A post is approved if it matches any rule, and it matches a rule if it matches all conditions that are set
+		AutoApprove []struct {
+			// Types are event types, e.g. "categorize"
+			Types []string `yaml:"types"`
+			// Subjects are "politician", "organization" or "talk"
+			Subjects []string `yaml:"subjects"`
+			// MaxSizeDifference is the maximum number of characters that were added or removed
+			MaxSizeDifference int `yaml:"max_size_difference"`
+		} `yaml:"auto_approve"`
+	} `yaml:"moderation"`
+
+	// Admin lets the configured accounts control the bot with direct messages, e.g. "pause" or "mute <title>".
+	// The app needs permission to read and write direct messages
+	Admin struct {
+		// Accounts are the screen names of the admins. Commands are disabled if it's empty
+		Accounts []string `yaml:"accounts"`
+		// Interval is the time between two checks for new messages. Defaults to one minute
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"admin"`
+
+	// Mute excludes articles and editors from posting, e.g. heavily vandalized articles or persons
+	// that are no longer politically active. More entries can be added with admin commands
+	Mute struct {
+		// File stores the entries added with admin commands, so they are kept after a restart.
+		// Without it, they are only kept in memory
+		File string `yaml:"file"`
+
+		Entries []struct {
+			// Exactly one of Article, QID, User and Range must be set.
+			// Range is an IP range in CIDR notation or a single IP address
+			Article string `yaml:"article"`
+			QID     string `yaml:"qid"`
+			User    string `yaml:"user"`
+			Range   string `yaml:"range"`
+
+			// Until is the time the mute ends, e.g. 2022-01-01T00:00:00Z. Empty means forever
+			Until time.Time `yaml:"until"`
+
+			Reason string `yaml:"reason"`
+		} `yaml:"entries"`
+	} `yaml:"mute"`
+
+	Filter struct {
+		// Levels restricts politicians to those that currently hold a position on one of these levels,
+		// e.g. "federal", "state" or "european". Empty means all politicians are posted about
+		Levels []string `yaml:"levels"`
+	} `yaml:"filter"`
+
+	// Attribution marks edits that were made from certain networks or by certain accounts
+	Attribution struct {
+		Networks []struct {
+			Name string `yaml:"name"`
+			// Text is added to the tweet, e.g. "Änderung aus dem Netz des Bundestags"
+			Text string `yaml:"text"`
+			// Ranges are in CIDR notation, e.g. "192.0.2.0/24"
+			Ranges []string `yaml:"ranges"`
+
+			// Party is the short name, hashtag or WikiData ID of the party this network belongs to, e.g. for party headquarters
+			Party string `yaml:"party"`
+			// Level is the level of the parliament this network belongs to, e.g. "federal" for the Bundestag
+			Level string `yaml:"level"`
+		} `yaml:"networks"`
+
+		Accounts []struct {
+			User string `yaml:"user"`
+			// Text is added to the tweet instead of the user name, which is never posted
+			Text string `yaml:"text"`
+		} `yaml:"accounts"`
+
+		// ConflictThreshold is the score from which an edit is treated as a possible self-edit. Defaults to 0.5
+		ConflictThreshold float64 `yaml:"conflict_threshold"`
+	} `yaml:"attribution"`
 }
 
 func Parse(filename string) (c Config, err error) {
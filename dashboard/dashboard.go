@@ -0,0 +1,154 @@
+// Package dashboard serves a small web page that shows the last decisions of the pipeline,
+// which makes it easier to find out why something was or wasn't posted
+package dashboard
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var indexTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "nie"
+		}
+		return time.Since(t).Round(time.Second).String()
+	},
+}).ParseFS(templateFS, "templates/index.html"))
+
+// Status is shown at the top of the dashboard
+type Status struct {
+	Politicians   int
+	Organizations int
+
+	Stream wikipedia.StreamStats
+	Pool   pipeline.PoolStats
+}
+
+// Dashboard keeps the last decisions in memory and serves them
+type Dashboard struct {
+	size   int
+	status func() Status
+
+	mu      sync.Mutex
+	entries []*entry
+	nextID  int
+}
+
+type entry struct {
+	ID       int
+	Time     time.Time
+	Decision pipeline.Decision
+	DiffURL  string
+}
+
+// New returns a dashboard that shows the last size decisions, defaults to 50.
+// status is called every time the page is loaded
+func New(size int, status func() Status) *Dashboard {
+	if size <= 0 {
+		size = 50
+	}
+
+	return &Dashboard{
+		size:   size,
+		status: status,
+	}
+}
+
+// Record adds a decision to the dashboard. It can be used as pipeline.Pipeline.OnDecision
+func (d *Dashboard) Record(dec pipeline.Decision) {
+	e := &entry{
+		Time:     time.Now(),
+		Decision: dec,
+		DiffURL:  dec.Item.DiffURL,
+	}
+	if e.DiffURL == "" {
+		// Skipped edits might not have gotten to the stage that sets it
+		e.DiffURL, _ = dec.Item.Event.DiffURL()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	e.ID = d.nextID
+
+	// Newest first
+	d.entries = append([]*entry{e}, d.entries...)
+	if len(d.entries) > d.size {
+		d.entries = d.entries[:d.size]
+	}
+}
+
+// ServeHTTP serves the dashboard on "/" and screenshots on "/screenshot/<id>.png".
+// Use http.StripPrefix if it should be served somewhere else
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == "":
+		d.serveIndex(w, r)
+	case strings.HasPrefix(r.URL.Path, "/screenshot/"):
+		d.serveScreenshot(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	var status Status
+	if d.status != nil {
+		status = d.status()
+	}
+
+	d.mu.Lock()
+	var entries = append([]*entry{}, d.entries...)
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := indexTemplate.Execute(w, map[string]interface{}{
+		"Status":  status,
+		"Entries": entries,
+		"Size":    d.size,
+	})
+	if err != nil {
+		logger.Error("rendering dashboard failed", "err", err)
+	}
+}
+
+func (d *Dashboard) serveScreenshot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/screenshot/"), ".png"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var png []byte
+
+	d.mu.Lock()
+	for _, e := range d.entries {
+		if e.ID == id {
+			png = e.Decision.Item.Image
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if len(png) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(png)
+}
@@ -0,0 +1,5 @@
+package dashboard
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "dashboard")
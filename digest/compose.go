@@ -0,0 +1,157 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxTweetLength = 280
+
+// Thread returns the texts of the posts of a digest: the top articles, the parties and links to the threads.
+// top is the maximum number of articles
+func Thread(s Summary, title string, top int) (texts []string) {
+	var articles = s.Articles
+	if len(articles) > top {
+		articles = articles[:top]
+	}
+
+	var text = title + ":\n"
+	for i, a := range articles {
+		line := fmt.Sprintf("\n%d. %s", i+1, a.Name)
+		if a.Party != "" {
+			line += " (" + a.Party + ")"
+		}
+		line += fmt.Sprintf(": %s, %s Bytes", edits(a.Edits), formatNumber(a.Bytes))
+
+		if tweetLength(text+line) > maxTweetLength {
+			// Only link the articles that are mentioned
+			articles = articles[:i]
+			break
+		}
+		text += line
+	}
+	texts = append(texts, text)
+
+	text = "Nach Partei:\n"
+	for _, p := range s.Parties {
+		line := fmt.Sprintf("\n%s: %s", p.Name, edits(p.Edits))
+		if tweetLength(text+line) > maxTweetLength {
+			break
+		}
+		text += line
+	}
+	texts = append(texts, text)
+
+	// Links can take many posts, as every link counts as 23 characters
+	text = ""
+	for _, a := range articles {
+		if a.ThreadURL == "" {
+			continue
+		}
+
+		line := a.Name + ": " + a.ThreadURL
+		if text != "" && tweetLength(text+"\n"+line) > maxTweetLength {
+			texts = append(texts, text)
+			text = ""
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+	}
+	if text != "" {
+		texts = append(texts, text)
+	}
+
+	return
+}
+
+func edits(n int) string {
+	if n == 1 {
+		return "1 Änderung"
+	}
+	return strconv.Itoa(n) + " Änderungen"
+}
+
+// formatNumber formats n with german thousands separators, e.g. "12.345"
+func formatNumber(n int) string {
+	s := strconv.Itoa(n)
+
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// period returns the dates of the summary, e.g. "01.08.2021 – 07.08.2021". Daily summaries only have one date
+func period(s Summary) string {
+	var to = s.To.Local().Format("02.01.2006")
+	if s.To.Sub(s.From) <= 24*time.Hour {
+		return to
+	}
+	return s.From.Local().Format("02.01.2006") + " – " + to
+}
+
+var urlRegex = regexp.MustCompile(`https?://\S+`)
+
+// tweetLength returns the length of text as twitter counts it, links are shortened to 23 characters
+func tweetLength(text string) int {
+	return len([]rune(urlRegex.ReplaceAllString(text, strings.Repeat("x", 23))))
+}
+
+//go:embed templates/chart.html
+var templateFS embed.FS
+
+var chartTemplate = template.Must(template.ParseFS(templateFS, "templates/chart.html"))
+
+// ChartSelector selects the chart in the HTML returned by ChartHTML
+const ChartSelector = "#chart"
+
+// ChartHTML returns a page with a bar chart of the top articles of the summary. It can be rendered with screenshot.HTML
+func ChartHTML(s Summary, title string, top int) (string, error) {
+	var articles = s.Articles
+	if len(articles) > top {
+		articles = articles[:top]
+	}
+
+	type bar struct {
+		Article
+		Percent float64
+	}
+
+	var (
+		bars []bar
+		max  = 1
+	)
+	for _, a := range articles {
+		if a.Edits > max {
+			max = a.Edits
+		}
+	}
+	for _, a := range articles {
+		bars = append(bars, bar{
+			Article: a,
+			Percent: 100 * float64(a.Edits) / float64(max),
+		})
+	}
+
+	var buf bytes.Buffer
+	err := chartTemplate.Execute(&buf, map[string]interface{}{
+		"Title":  title,
+		"Period": period(s),
+		"Bars":   bars,
+	})
+
+	return buf.String(), err
+}
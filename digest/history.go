@@ -0,0 +1,194 @@
+// Package digest posts daily and weekly summaries of the most edited articles about politicians
+package digest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// record is an edit of an article about a politician
+type record struct {
+	Time  time.Time
+	Title string
+	Name  string
+	Party string
+	Bytes int
+
+	// PostURL is the URL of the post about the edit, if there was one
+	PostURL string
+}
+
+// History remembers edits of articles about politicians for digests. It's only kept in memory,
+// but can be filled with Add after a restart
+type History struct {
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	records []record
+}
+
+// NewHistory returns a history that keeps edits for maxAge, defaults to eight days
+func NewHistory(maxAge time.Duration) *History {
+	if maxAge <= 0 {
+		maxAge = 8 * 24 * time.Hour
+	}
+
+	return &History{
+		maxAge: maxAge,
+	}
+}
+
+// Record adds the edit of the decision to the history. It can be used as pipeline.Pipeline.OnDecision.
+// Only edits and new articles about politicians are counted, no matter whether they were posted.
+// The time of the event is used, like in the stats store, so the history can be restored from there
+func (h *History) Record(d pipeline.Decision) {
+	var (
+		e    = d.Item.Event
+		poli = d.Item.Subject.Politician
+	)
+	if poli == nil || e.IsTalkPage() || (e.Type != wikipedia.TypeEdit && e.Type != wikipedia.TypeNew) {
+		return
+	}
+
+	var postURL string
+	if len(d.Posts) > 0 {
+		postURL = d.Posts[0].URL
+	}
+
+	h.Add(e.Time(), d.Item.Subject.Title, poli.Name, poli.PartyShortname(), e.SizeDifference(), postURL)
+}
+
+// Add adds an edit to the history, e.g. one that was recorded before a restart.
+// Edits can be added in any order, e.g. events can arrive late from the stream
+func (h *History) Add(t time.Time, title, name, party string, bytes int, postURL string) {
+	r := record{
+		Time:    t,
+		Title:   title,
+		Name:    name,
+		Party:   party,
+		Bytes:   bytes,
+		PostURL: postURL,
+	}
+	if r.Name == "" {
+		r.Name = r.Title
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Keep the records sorted by time. Edits with the same time stay in the order they were added
+	i := sort.Search(len(h.records), func(i int) bool {
+		return h.records[i].Time.After(t)
+	})
+	h.records = append(h.records, record{})
+	copy(h.records[i+1:], h.records[i:])
+	h.records[i] = r
+
+	// Old records are at the front
+	var cutoff = time.Now().Add(-h.maxAge)
+	for len(h.records) > 0 && h.records[0].Time.Before(cutoff) {
+		h.records = h.records[1:]
+	}
+}
+
+// Article is an article in a summary
+type Article struct {
+	Title string
+	Name  string
+	Party string
+
+	Edits int
+	Bytes int
+
+	// ThreadURL is the URL of the first post about the article within the time of the summary, could be empty
+	ThreadURL string
+}
+
+// Party is a party in a summary, with the edits of articles about its members
+type Party struct {
+	Name  string
+	Edits int
+}
+
+// Summary describes the edits within a time span
+type Summary struct {
+	From, To time.Time
+
+	Edits int
+
+	// Articles and Parties are sorted by the number of edits, most edits first
+	Articles []Article
+	Parties  []Party
+}
+
+// Summary returns the summary of all edits between from and to
+func (h *History) Summary(from, to time.Time) (s Summary) {
+	s.From, s.To = from, to
+
+	var (
+		articles = make(map[string]*Article)
+		parties  = make(map[string]*Party)
+	)
+
+	h.mu.Lock()
+	for _, r := range h.records {
+		if r.Time.Before(from) || !r.Time.Before(to) {
+			continue
+		}
+		s.Edits++
+
+		a, ok := articles[r.Title]
+		if !ok {
+			a = &Article{Title: r.Title, Name: r.Name, Party: r.Party}
+			articles[r.Title] = a
+		}
+		a.Edits++
+		a.Bytes += r.Bytes
+		if a.ThreadURL == "" {
+			a.ThreadURL = r.PostURL
+		}
+
+		var party = r.Party
+		if party == "" {
+			party = "ohne Partei"
+		}
+		p, ok := parties[party]
+		if !ok {
+			p = &Party{Name: party}
+			parties[party] = p
+		}
+		p.Edits++
+	}
+	h.mu.Unlock()
+
+	for _, a := range articles {
+		s.Articles = append(s.Articles, *a)
+	}
+	sort.Slice(s.Articles, func(i, j int) bool {
+		a, b := s.Articles[i], s.Articles[j]
+		if a.Edits != b.Edits {
+			return a.Edits > b.Edits
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Title < b.Title
+	})
+
+	for _, p := range parties {
+		s.Parties = append(s.Parties, *p)
+	}
+	sort.Slice(s.Parties, func(i, j int) bool {
+		a, b := s.Parties[i], s.Parties[j]
+		if a.Edits != b.Edits {
+			return a.Edits > b.Edits
+		}
+		return a.Name < b.Name
+	})
+
+	return
+}
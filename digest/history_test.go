@@ -0,0 +1,56 @@
+package digest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+func TestHistoryAddOutOfOrder(t *testing.T) {
+	var (
+		now = time.Now()
+		h   = NewHistory(24 * time.Hour)
+	)
+
+	// A late edit must not make the history drop newer ones, and old edits must still be removed
+	h.Add(now.Add(-time.Hour), "Max Mustermann", "Max Mustermann", "SPD", 100, "")
+	h.Add(now.Add(-48*time.Hour), "Erika Musterfrau", "Erika Musterfrau", "CDU", 100, "")
+	h.Add(now.Add(-3*time.Hour), "Erika Musterfrau", "Erika Musterfrau", "CDU", 100, "")
+	h.Add(now.Add(-2*time.Hour), "Max Mustermann", "Max Mustermann", "SPD", 100, "")
+
+	if n := len(h.records); n != 3 {
+		t.Fatalf("got %d records, want 3", n)
+	}
+	for i := 1; i < len(h.records); i++ {
+		if h.records[i].Time.Before(h.records[i-1].Time) {
+			t.Errorf("records are not sorted by time: %v", h.records)
+		}
+	}
+
+	s := h.Summary(now.Add(-24*time.Hour), now)
+	if s.Edits != 3 || len(s.Articles) != 2 || s.Articles[0].Title != "Max Mustermann" || s.Articles[0].Edits != 2 {
+		t.Errorf("got summary %+v, want 2 edits of Max Mustermann and one of Erika Musterfrau", s)
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	h := NewHistory(0)
+
+	e := streamtest.Edit("Max Mustermann", 1000, 400)
+	e.Timestamp = int(time.Now().Add(-time.Hour).Unix())
+
+	h.Record(pipeline.Decision{Item: pipeline.Item{
+		Event:   e,
+		Subject: pipeline.Subject{Title: "Max Mustermann", Politician: &wikidata.Politician{Name: "Max Mustermann"}},
+	}})
+
+	if len(h.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(h.records))
+	}
+	if r := h.records[0]; !r.Time.Equal(e.Time()) || r.Bytes != 600 {
+		t.Errorf("got record %+v, want the time of the event and 600 bytes", r)
+	}
+}
@@ -0,0 +1,5 @@
+package digest
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "digest")
@@ -0,0 +1,138 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+)
+
+// Titles of the digests
+const (
+	TitleDaily  = "Die meistbearbeiteten Politiker-Artikel heute"
+	TitleWeekly = "Die meistbearbeiteten Politiker-Artikel dieser Woche"
+)
+
+// Scheduler posts digests of the history as threads
+type Scheduler struct {
+	History   *History
+	Publisher pipeline.Publisher
+
+	// Daily posts a digest of the last 24 hours every day at At.
+	// Weekly posts one of the last seven days on Weekday at At
+	Daily   bool
+	Weekly  bool
+	Weekday time.Weekday
+
+	// At is the local time of day, e.g. 20*time.Hour for 20:00
+	At time.Duration
+
+	// Top is the number of articles in a digest, defaults to 5
+	Top int
+
+	// Chart renders the chart for the first post, it gets the result of ChartHTML and ChartSelector.
+	// Optional, e.g. screenshot.HTML
+	Chart func(html, sel string) ([]byte, error)
+
+	// Controls are the ones of the pipeline. No digests are posted while it's paused, optional
+	Controls *pipeline.Controls
+}
+
+// Run posts digests until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := nextTime(time.Now(), s.At)
+		logger.Debug("waiting for next digest", "time", next)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		now := time.Now()
+
+		if s.Daily {
+			err := s.Publish(ctx, TitleDaily, now.Add(-24*time.Hour), now)
+			if err != nil {
+				logger.Error("posting daily digest failed", "err", err)
+			}
+		}
+
+		if s.Weekly && now.Weekday() == s.Weekday {
+			err := s.Publish(ctx, TitleWeekly, now.AddDate(0, 0, -7), now)
+			if err != nil {
+				logger.Error("posting weekly digest failed", "err", err)
+			}
+		}
+	}
+}
+
+// nextTime returns the first time after now that is at the given time of day
+func nextTime(now time.Time, at time.Duration) time.Time {
+	var (
+		y, m, d = now.Date()
+		hour    = int(at / time.Hour)
+		minute  = int(at % time.Hour / time.Minute)
+	)
+
+	t := time.Date(y, m, d, hour, minute, 0, 0, now.Location())
+	if !t.After(now) {
+		t = time.Date(y, m, d+1, hour, minute, 0, 0, now.Location())
+	}
+
+	return t
+}
+
+// Publish posts the digest of the edits between from and to. Nothing is posted if there were no edits or posting is paused
+func (s *Scheduler) Publish(ctx context.Context, title string, from, to time.Time) error {
+	if s.Controls != nil && s.Controls.Paused() {
+		logger.Info("paused, not posting digest", "title", title)
+		return nil
+	}
+
+	var top = s.Top
+	if top <= 0 {
+		top = 5
+	}
+
+	summary := s.History.Summary(from, to)
+	if summary.Edits == 0 {
+		logger.Info("no edits, not posting digest", "title", title)
+		return nil
+	}
+
+	var image []byte
+	if s.Chart != nil {
+		html, err := ChartHTML(summary, title, top)
+		if err == nil {
+			image, err = s.Chart(html, ChartSelector)
+		}
+		if err != nil {
+			logger.Warn("rendering chart failed, posting digest without it", "err", err)
+		}
+	}
+
+	// All posts of the digest are added to the same thread
+	var threadKey = fmt.Sprintf("digest %s %s", title, to.Format(time.RFC3339))
+
+	for i, text := range Thread(summary, title, top) {
+		post := pipeline.Post{
+			ThreadKey: threadKey,
+			Text:      text,
+		}
+		if i == 0 {
+			post.Image = image
+		}
+
+		pub, err := s.Publisher.Publish(ctx, post)
+		if err != nil {
+			return fmt.Errorf("publishing post %d of the digest: %w", i+1, err)
+		}
+
+		logger.Info("posted digest", "title", title, "part", i+1, "url", pub.URL, "in_reply_to", pub.InReplyTo)
+	}
+
+	return nil
+}
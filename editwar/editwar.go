@@ -0,0 +1,241 @@
+package editwar
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// Alert is emitted when an article sees many edits and reverts in a short time or gets protected
+type Alert struct {
+	Title string
+
+	// Edits are all edits to the article within the window, oldest first
+	Edits []wikipedia.Event
+
+	// Reverts is the number of edits that reverted a previous edit
+	Reverts int
+
+	// Editors is the number of different users that edited the article
+	Editors int
+
+	// Protection is the protection log event if the alert was caused by one
+	Protection *wikipedia.Event
+}
+
+type entry struct {
+	event  wikipedia.Event
+	time   time.Time
+	revert bool
+}
+
+type history struct {
+	entries   []entry
+	lastAlert time.Time
+}
+
+// Detector keeps a sliding window of the edits of every article and detects edit wars
+type Detector struct {
+	// Window is how far back edits are considered
+	Window time.Duration
+
+	// MinEdits and MinReverts must both be reached within the window for an edit war alert
+	MinEdits, MinReverts int
+
+	mu       sync.Mutex
+	articles map[string]*history
+}
+
+// New returns a detector that alerts once an article had at least minEdits edits, of which
+// at least minReverts were reverts, within the window. After an alert, the next one for the
+// same article is only emitted once another window has passed.
+// Zero values default to one hour, 6 edits and 3 reverts
+func New(window time.Duration, minEdits, minReverts int) *Detector {
+	if window <= 0 {
+		window = time.Hour
+	}
+	if minEdits <= 0 {
+		minEdits = 6
+	}
+	if minReverts <= 0 {
+		minReverts = 3
+	}
+
+	return &Detector{
+		Window:     window,
+		MinEdits:   minEdits,
+		MinReverts: minReverts,
+		articles:   make(map[string]*history),
+	}
+}
+
+// Add adds an event from the stream. Edits are recorded and new protections always
+// result in an alert that contains the edits leading up to the protection.
+// Other protection log actions like "unprotect" and "modify" are ignored
+func (d *Detector) Add(e wikipedia.Event) (alert Alert, ok bool) {
+	var protected = isProtect(e)
+	if e.Type != wikipedia.TypeEdit && !protected {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var now = e.Time()
+
+	h, exists := d.articles[e.Title]
+	if !exists {
+		h = new(history)
+		d.articles[e.Title] = h
+	}
+
+	h.prune(now.Add(-d.Window))
+
+	if protected {
+		protection := e
+		h.lastAlert = now
+		return h.alert(e.Title, &protection), true
+	}
+
+	h.entries = append(h.entries, entry{
+		event:  e,
+		time:   now,
+		revert: isRevert(e, h.entries),
+	})
+
+	// Don't alert about the same edit war twice
+	if now.Sub(h.lastAlert) < d.Window {
+		return
+	}
+
+	alert = h.alert(e.Title, nil)
+	if len(alert.Edits) < d.MinEdits || alert.Reverts < d.MinReverts {
+		return Alert{}, false
+	}
+
+	h.lastAlert = now
+
+	return alert, true
+}
+
+// Cleanup removes articles that haven't been edited within the window, so memory usage doesn't grow forever
+func (d *Detector) Cleanup(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for title, h := range d.articles {
+		h.prune(now.Add(-d.Window))
+		if len(h.entries) == 0 && now.Sub(h.lastAlert) >= d.Window {
+			delete(d.articles, title)
+		}
+	}
+}
+
+// prune removes all entries before the given time
+func (h *history) prune(before time.Time) {
+	var i int
+	for i < len(h.entries) && h.entries[i].time.Before(before) {
+		i++
+	}
+	h.entries = h.entries[i:]
+}
+
+func (h *history) alert(title string, protection *wikipedia.Event) (a Alert) {
+	a.Title = title
+	a.Protection = protection
+
+	var editors = make(map[string]bool)
+	for _, en := range h.entries {
+		a.Edits = append(a.Edits, en.event)
+		if en.revert {
+			a.Reverts++
+		}
+		editors[en.event.User] = true
+	}
+	a.Editors = len(editors)
+
+	return
+}
+
+// Comments of reverts on the german wikipedia, e.g. "Änderung 123 von X rückgängig gemacht" or
+// "Änderungen von X rückgängig gemacht und letzte Version von Y wiederhergestellt"
+var revertComments = []string{"rückgängig gemacht", "zurückgesetzt", "wiederhergestellt", "revert"}
+
+// isRevert returns whether the edit seems to revert a previous edit. That's the case if the comment says so
+// or if the article has exactly the same length as before one of the previous edits
+func isRevert(e wikipedia.Event, previous []entry) bool {
+	var comment = strings.ToLower(e.Comment)
+	for _, c := range revertComments {
+		if strings.Contains(comment, c) {
+			return true
+		}
+	}
+
+	if e.Length.New == e.Length.Old {
+		return false
+	}
+
+	for _, p := range previous {
+		if p.event.Length.Old == e.Length.New && p.event.Length.New != p.event.Length.Old {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isProtect returns whether the event is a new protection of a page
+func isProtect(e wikipedia.Event) bool {
+	return e.IsProtection() && e.LogAction == "protect"
+}
+
+// Tweets returns the tweets for the alert, the first one is a summary and the others list the diffs of the edits.
+// nameText is the name of the politician or organization
+func (a *Alert) Tweets(nameText string, window time.Duration) (tweets []string) {
+	var summary string
+	if a.Protection != nil {
+		summary = fmt.Sprintf("Der Wiki-Eintrag zu %s wurde geschützt", nameText)
+		if a.Protection.LogParams.Description != "" {
+			summary += ":\n" + a.Protection.LogParams.Description
+		}
+		if len(a.Edits) > 0 {
+			summary += fmt.Sprintf("\nDavor gab es %d Änderungen von %d Benutzern, davon %d Rücksetzungen", len(a.Edits), a.Editors, a.Reverts)
+		}
+		summary += "\n" + a.Protection.LogURL()
+	} else {
+		summary = fmt.Sprintf("Möglicher Edit-War beim Wiki-Eintrag zu %s: %d Änderungen von %d Benutzern, davon %d Rücksetzungen in %s",
+			nameText, len(a.Edits), a.Editors, a.Reverts, formatWindow(window))
+	}
+	tweets = append(tweets, summary)
+
+	var diffs []string
+	for _, e := range a.Edits {
+		if u, ok := e.DiffURL(); ok {
+			diffs = append(diffs, u)
+		}
+	}
+
+	// Every link counts as 23 characters, so a few of them fit into one tweet
+	const perTweet = 5
+	for i := 0; i < len(diffs); i += perTweet {
+		end := i + perTweet
+		if end > len(diffs) {
+			end = len(diffs)
+		}
+
+		tweets = append(tweets, fmt.Sprintf("Änderungen (%d-%d von %d):\n%s", i+1, end, len(diffs), strings.Join(diffs[i:end], "\n")))
+	}
+
+	return
+}
+
+// formatWindow formats the duration in german, e.g. "60 Minuten" or "2 Stunden"
+func formatWindow(d time.Duration) string {
+	if d >= 2*time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d Stunden", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d Minuten", int(d.Minutes()))
+}
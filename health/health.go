@@ -0,0 +1,153 @@
+// Package health provides the /healthz and /readyz endpoints, e.g. for restarting the bot if it gets stuck
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checker keeps track of whether the bot is ready and healthy
+type Checker struct {
+	maxEventAge       time.Duration
+	maxRenderFailures int
+
+	mu sync.Mutex
+
+	// ready maps everything that must happen before we are ready to whether it happened
+	ready map[string]bool
+
+	lastEvent    func() time.Time
+	watchedSince time.Time
+
+	renderFailures int
+}
+
+// New returns a checker that is unhealthy if no event was received for maxEventAge or if maxRenderFailures
+// screenshots in a row failed. They default to 10 minutes and 5 failures.
+// required are the names of everything that must be done before the bot is ready, see Ready
+func New(maxEventAge time.Duration, maxRenderFailures int, required ...string) *Checker {
+	if maxEventAge <= 0 {
+		maxEventAge = 10 * time.Minute
+	}
+	if maxRenderFailures <= 0 {
+		maxRenderFailures = 5
+	}
+
+	c := &Checker{
+		maxEventAge:       maxEventAge,
+		maxRenderFailures: maxRenderFailures,
+		ready:             make(map[string]bool),
+	}
+	for _, r := range required {
+		c.ready[r] = false
+	}
+
+	return c
+}
+
+// Ready marks the required step as done, e.g. "politicians" after they were loaded
+func (c *Checker) Ready(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ready[name] = true
+}
+
+// WatchEvents starts checking the time of the last event from the stream. lastEvent returns
+// the zero time if no event was received yet, in that case the time WatchEvents was called is used
+func (c *Checker) WatchEvents(lastEvent func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastEvent = lastEvent
+	c.watchedSince = time.Now()
+}
+
+// RenderSucceeded resets the counter of failed screenshots
+func (c *Checker) RenderSucceeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.renderFailures = 0
+}
+
+// RenderFailed counts a failed screenshot
+func (c *Checker) RenderFailed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.renderFailures++
+}
+
+// CheckReady returns why the bot is not ready yet. It's ready if problems is empty
+func (c *Checker) CheckReady() (problems []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, done := range c.ready {
+		if !done {
+			problems = append(problems, "waiting for "+name)
+		}
+	}
+	sort.Strings(problems)
+
+	return
+}
+
+// CheckHealth returns why the bot is unhealthy. It's healthy if problems is empty
+func (c *Checker) CheckHealth() (problems []string) {
+	c.mu.Lock()
+	var (
+		lastEvent    = c.lastEvent
+		watchedSince = c.watchedSince
+		failures     = c.renderFailures
+	)
+	c.mu.Unlock()
+
+	if lastEvent != nil {
+		last := lastEvent()
+		if last.IsZero() {
+			last = watchedSince
+		}
+
+		if age := time.Since(last); age > c.maxEventAge {
+			problems = append(problems, fmt.Sprintf("no event from the stream for %s", age.Round(time.Second)))
+		}
+	}
+
+	if failures >= c.maxRenderFailures {
+		problems = append(problems, fmt.Sprintf("the last %d screenshots failed", failures))
+	}
+
+	return
+}
+
+// HealthHandler serves /healthz
+func (c *Checker) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, c.CheckHealth())
+	})
+}
+
+// ReadyHandler serves /readyz
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, c.CheckReady())
+	})
+}
+
+func writeStatus(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,330 @@
+// Package logging writes structured log lines in logfmt or JSON.
+// Every line has a time, a level and a message, plus any number of key-value pairs, e.g.
+//
+//	time=2021-08-01T12:00:00Z level=info component=pipeline rev=123456 msg="posted" url=https://twitter.com/...
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Levels, lines below the level of a logger are discarded
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return strconv.Itoa(int(l))
+	}
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error". An empty string is LevelInfo
+func ParseLevel(s string) (l Level, err error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+// Formats of log lines
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// output is shared by a logger and all loggers derived from it with With
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+}
+
+// Logger writes log lines. Loggers are safe for concurrent use
+type Logger struct {
+	out *output
+
+	// fields are added to every line, as alternating keys and values
+	fields []interface{}
+}
+
+// New returns a logger that writes lines with at least the given level to w.
+// format is FormatLogfmt or FormatJSON, anything else is treated like FormatLogfmt
+func New(w io.Writer, format string, level Level) *Logger {
+	return &Logger{
+		out: &output{
+			w:      w,
+			format: format,
+			level:  level,
+		},
+	}
+}
+
+var defaultLogger = New(os.Stderr, FormatLogfmt, LevelInfo)
+
+// Default returns the default logger
+func Default() *Logger {
+	return defaultLogger
+}
+
+// Configure changes where and how the default logger and all loggers derived from it write lines.
+// Loggers derived with With before calling Configure are affected too
+func Configure(w io.Writer, format string, level Level) {
+	defaultLogger.out.set(w, format, level)
+}
+
+func (o *output) set(w io.Writer, format string, level Level) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.w = w
+	o.format = format
+	o.level = level
+}
+
+// With returns a logger of the default logger that adds the key-value pairs to every line
+func With(kv ...interface{}) *Logger {
+	return Default().With(kv...)
+}
+
+// With returns a logger that adds the key-value pairs to every line
+func (l *Logger) With(kv ...interface{}) *Logger {
+	if l == nil {
+		l = Default()
+	}
+
+	return &Logger{
+		out:    l.out,
+		fields: append(append([]interface{}{}, l.fields...), kv...),
+	}
+}
+
+// Enabled returns whether lines with the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		l = Default()
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	return level >= l.out.level
+}
+
+// Debug writes a line with LevelDebug. kv are alternating keys and values
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.Log(LevelDebug, msg, kv...)
+}
+
+// Info writes a line with LevelInfo
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.Log(LevelInfo, msg, kv...)
+}
+
+// Warn writes a line with LevelWarn
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.Log(LevelWarn, msg, kv...)
+}
+
+// Error writes a line with LevelError
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.Log(LevelError, msg, kv...)
+}
+
+// Log writes a line with the given level. A nil logger writes to the default logger
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if l == nil {
+		l = Default()
+	}
+
+	if !l.Enabled(level) {
+		return
+	}
+
+	var fields = make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339), "level", level.String())
+	fields = append(fields, l.fields...)
+	fields = append(fields, "msg", msg)
+	fields = append(fields, kv...)
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	var buf bytes.Buffer
+	if l.out.format == FormatJSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+// Writer returns a writer that logs every line written to it with the given level,
+// e.g. for log.SetOutput so output of other packages ends up in the same format
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.l.Log(w.level, line)
+	}
+	return len(p), nil
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(key(fields[i]))
+		buf.WriteByte('=')
+
+		if i+1 < len(fields) {
+			buf.WriteString(logfmtValue(value(fields[i+1])))
+		}
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, _ := marshal(key(fields[i]))
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		var v interface{}
+		if i+1 < len(fields) {
+			v = fields[i+1]
+		}
+
+		switch val := v.(type) {
+		case error:
+			v = val.Error()
+		case fmt.Stringer:
+			v = val.String()
+		}
+
+		data, err := marshal(v)
+		if err != nil {
+			data, _ = marshal(fmt.Sprint(v))
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+}
+
+// marshal is like json.Marshal, but doesn't escape "&", "<" and ">", which are common in URLs
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), err
+}
+
+func key(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	return fmt.Sprint(k)
+}
+
+func value(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case time.Time:
+		return val.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// logfmtValue quotes the value if it contains spaces, quotes, equal signs or control characters
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '=' || r == '\\' || unicode.IsControl(r) {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
+
+// Package-level functions that use the default logger
+
+// Debug writes a line with LevelDebug to the default logger
+func Debug(msg string, kv ...interface{}) {
+	Default().Log(LevelDebug, msg, kv...)
+}
+
+// Info writes a line with LevelInfo to the default logger
+func Info(msg string, kv ...interface{}) {
+	Default().Log(LevelInfo, msg, kv...)
+}
+
+// Warn writes a line with LevelWarn to the default logger
+func Warn(msg string, kv ...interface{}) {
+	Default().Log(LevelWarn, msg, kv...)
+}
+
+// Error writes a line with LevelError to the default logger
+func Error(msg string, kv ...interface{}) {
+	Default().Log(LevelError, msg, kv...)
+}
@@ -1,135 +1,600 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/xarantolus/poliwiki/admin"
+	"github.com/xarantolus/poliwiki/attribution"
 	"github.com/xarantolus/poliwiki/bot"
 	"github.com/xarantolus/poliwiki/config"
+	"github.com/xarantolus/poliwiki/dashboard"
+	"github.com/xarantolus/poliwiki/digest"
+	"github.com/xarantolus/poliwiki/editwar"
+	"github.com/xarantolus/poliwiki/health"
+	"github.com/xarantolus/poliwiki/logging"
+	"github.com/xarantolus/poliwiki/metrics"
+	"github.com/xarantolus/poliwiki/moderation"
+	"github.com/xarantolus/poliwiki/mute"
+	"github.com/xarantolus/poliwiki/pipeline"
 	"github.com/xarantolus/poliwiki/screenshot"
-	"github.com/xarantolus/poliwiki/util"
+	"github.com/xarantolus/poliwiki/stats"
 	"github.com/xarantolus/poliwiki/wikidata"
 	"github.com/xarantolus/poliwiki/wikipedia"
-
-	"github.com/dghubble/go-twitter/twitter"
 )
 
 var (
 	flagConfigFile = flag.String("cfg", "config.yaml", "Config file path")
+	flagExport     = flag.String("export", "", "Export all politicians in the given format (json or csv) to stdout and exit")
+	flagLookup     = flag.String("lookup", "", "Look up politicians or organizations, print them as JSON and exit. The query is a WikiData ID, a name or one of \"party:<name>\", \"position:<name>\" and \"org:<name>\"")
+	flagStats      = flag.String("stats", "", "Run a query on the stats store and exit, e.g. \"group=week,article&party=SPD&since=30d\"")
 )
 
 func main() {
 	flag.Parse()
 
+	if *flagExport != "" {
+		export(*flagExport)
+		return
+	}
+
+	if *flagLookup != "" {
+		lookup(*flagLookup)
+		return
+	}
+
 	cfg, err := config.Parse(*flagConfigFile)
 	if err != nil {
 		panic("parsing configuration file: " + err.Error())
 	}
 
-	log.Println("[Startup] Fetching politicians...")
+	if *flagStats != "" {
+		queryStats(cfg, *flagStats)
+		return
+	}
+
+	// The moderation page can publish any text, so it must never be reachable without a password
+	if cfg.Moderation.Enabled && (cfg.Moderation.User == "" || cfg.Moderation.Password == "") {
+		panic("moderation is enabled, but moderation.user or moderation.password is not set")
+	}
+
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		panic("parsing log level: " + err.Error())
+	}
+	logging.Configure(os.Stderr, cfg.Log.Format, level)
+
+	// Other packages still use the standard logger, their lines should have the same format
+	log.SetFlags(0)
+	log.SetOutput(logging.Default().Writer(logging.LevelInfo))
+
+	logger := logging.With("component", "main")
+
+	checker := health.New(cfg.HTTP.MaxEventAge, cfg.HTTP.MaxRenderFailures, "politicians", "organizations", "twitter")
+
+	// More handlers are added once everything is loaded
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", checker.HealthHandler())
+	mux.Handle("/readyz", checker.ReadyHandler())
+
+	if cfg.HTTP.Address != "" {
+		go func() {
+			logger.Info("starting HTTP server", "address", cfg.HTTP.Address)
+			err := http.ListenAndServe(cfg.HTTP.Address, mux)
+			logger.Error("HTTP server stopped", "err", err)
+		}()
+	}
+
+	logger.Info("fetching politicians")
 
 	poliStore, err := wikidata.Politicians()
 	if err != nil {
 		panic("fetching politicians: " + err.Error())
 	}
 
-	log.Printf("[Startup] Got info about %d politicians\n", poliStore.Len())
+	logger.Info("fetched politicians", "count", poliStore.Len())
+	checker.Ready("politicians")
+
+	logger.Info("fetching parties, parliaments and ministries")
+
+	orgStore, err := wikidata.Organizations()
+	if err != nil {
+		panic("fetching organizations: " + err.Error())
+	}
+
+	logger.Info("fetched organizations", "count", orgStore.Len())
+	checker.Ready("organizations")
 
 	client, user, err := bot.Login(cfg)
 	if err != nil {
 		panic("logging in to twitter: " + err.Error())
 	}
-	log.Printf("[Twitter] Logged in @%s\n", user.ScreenName)
+	logger.Info("logged in to twitter", "user", user.ScreenName)
+	checker.Ready("twitter")
+
+	attributions, err := attribution.New(cfg)
+	if err != nil {
+		panic("loading attribution config: " + err.Error())
+	}
+
+	var conflictThreshold = cfg.Attribution.ConflictThreshold
+	if conflictThreshold <= 0 {
+		conflictThreshold = 0.5
+	}
+
+	// Only politicians that hold a position on one of these levels are posted about
+	var levels []wikidata.Level
+	for _, l := range cfg.Filter.Levels {
+		levels = append(levels, wikidata.Level(l))
+	}
+
+	var eventTypes = cfg.Stream.Types
+	if len(eventTypes) == 0 {
+		eventTypes = []string{wikipedia.TypeEdit}
+	}
+	var streamTypes = eventTypes
+
+	matcher := &pipeline.StoreMatcher{
+		Politicians:   &poliStore,
+		Organizations: &orgStore,
+		Levels:        levels,
+		TalkPages:     cfg.TalkPages.Enabled,
+		Resolve:       wikidata.PoliticianByTitle,
+	}
+
+	var observers []pipeline.Observer
+	if cfg.EditWar.Enabled {
+		detector := editwar.New(cfg.EditWar.Window, cfg.EditWar.MinEdits, cfg.EditWar.MinReverts)
+
+		go func() {
+			for range time.Tick(time.Hour) {
+				detector.Cleanup(time.Now())
+			}
+		}()
 
-	events := wikipedia.StreamEdits(func(e *wikipedia.Event) bool {
-		return poliStore.Contains(e.Title)
+		observers = append(observers, &pipeline.EditWarObserver{Detector: detector})
+
+		// The detector needs protection log events, even if we don't post the other log events
+		if !contains(eventTypes, wikipedia.TypeLog) {
+			streamTypes = append(append([]string{}, eventTypes...), wikipedia.TypeLog)
+		}
+	}
+
+	mutes, err := mute.New(cfg)
+	if err != nil {
+		panic("loading mute list: " + err.Error())
+	}
+
+	var filters = []pipeline.Filter{
+		&pipeline.TypeFilter{Types: eventTypes},
+		pipeline.DiffFilter{},
+		&pipeline.AttributionFilter{
+			Analyzer:          attributions,
+			ConflictThreshold: conflictThreshold,
+		},
+		&pipeline.SizeFilter{MinSize: 50},
+	}
+	if cfg.TalkPages.Enabled {
+		filters = append(filters, talkPageFilter(cfg))
+	}
+
+	publisher := &pipeline.TwitterPublisher{
+		Client:     client,
+		ScreenName: user.ScreenName,
+	}
+
+	// Pausing stops everything that posts, not only the pipeline
+	controls := &pipeline.Controls{Mutes: mutes}
+
+	limiter := rateLimiter(cfg, client, publisher)
+	limiter.Controls = controls
+	go limiter.Run(context.Background())
+
+	metrics.NewGaugeFunc("poliwiki_rate_limit_queued", "Posts that wait because of a rate limit, including the ones for the next digest", func() float64 {
+		s := limiter.Stats()
+		return float64(s.Queued + s.Digest)
 	})
 
-	type lastInfo struct {
-		TweetID int64
-		Time    time.Time
+	p := &pipeline.Pipeline{
+		Matcher:   matcher,
+		Observers: observers,
+		Filters:   filters,
+		Renderer:  pipeline.ScreenshotRenderer{},
+		Composer:  pipeline.TextComposer{},
+		Publisher: limiter,
+		Controls:  controls,
 	}
 
-	// For detecting if we tweeted about the same entry within the last two hours
-	var lastTweetInfo = make(map[string]lastInfo)
+	var queue *moderation.Queue
+
+	if cfg.Moderation.Enabled {
+		var rules []moderation.Rule
+		for _, r := range cfg.Moderation.AutoApprove {
+			rules = append(rules, moderation.Rule{
+				Types:             r.Types,
+				Subjects:          r.Subjects,
+				MaxSizeDifference: r.MaxSizeDifference,
+			})
+		}
+
+		queue = moderation.New(limiter, rules, cfg.Moderation.MaxAge)
+		p.Moderator = queue
 
-	for edit := range events {
-		log.Printf("[Edit]: %#v\n", edit)
+		mux.Handle("/moderation/", http.StripPrefix("/moderation", queue.Handler(cfg.Moderation.User, cfg.Moderation.Password)))
 
-		poli, ok := poliStore.Get(edit.Title)
-		if !ok {
-			log.Printf("[Skip] Couldn't find %q in poliStore, even though only titles in there should reach this point\n", edit.Title)
-			continue
+		if cfg.HTTP.Address == "" {
+			logger.Warn("moderation is enabled, but there's no HTTP address to approve posts")
 		}
+	}
+
+	pool := &pipeline.Pool{
+		Pipeline:     p,
+		Workers:      cfg.Workers.Count,
+		QueueSize:    cfg.Workers.QueueSize,
+		DropWhenFull: cfg.Workers.DropWhenFull,
+	}
+
+	metrics.NewGaugeFunc("poliwiki_pool_waiting", "Events that are waiting for a worker", func() float64 {
+		return float64(pool.Stats().Waiting)
+	})
 
-		diffURL, ok := edit.DiffURL()
-		if !ok {
-			log.Printf("[Skip] Couldn't generate diff URL for edit %#v\n", edit)
-			continue
+	source := &pipeline.StreamSource{
+		Streamer: wikipedia.Streamer{
+			Types:     streamTypes,
+			Overflow:  cfg.Stream.Overflow,
+			SpillFile: cfg.Stream.SpillFile,
+		},
+		Accept: matcher.Accept,
+	}
+
+	dash := dashboard.New(cfg.HTTP.DashboardSize, func() dashboard.Status {
+		return dashboard.Status{
+			Politicians:   poliStore.Len(),
+			Organizations: orgStore.Len(),
+			Stream:        source.Streamer.Stats(),
+			Pool:          pool.Stats(),
 		}
+	})
+	mux.Handle("/dashboard/", http.StripPrefix("/dashboard", dash))
+
+	if len(cfg.Admin.Accounts) > 0 {
+		a := &admin.Admin{
+			Client:     client,
+			Admins:     cfg.Admin.Accounts,
+			Interval:   cfg.Admin.Interval,
+			Controls:   p.Controls,
+			Mutes:      mutes,
+			Moderation: queue,
+			Status: func() string {
+				s := pool.Stats()
+				st := source.Streamer.Stats()
+
+				var lastEvent = "nie"
+				if !st.LastEvent.IsZero() {
+					lastEvent = "vor " + time.Since(st.LastEvent).Round(time.Second).String()
+				}
 
-		if edit.SizeDifference() < 50 {
-			log.Println("[Skip] Skipping small edit", diffURL)
-			continue
+				lines := fmt.Sprintf("Stream verbunden: %t, letztes Ereignis: %s\nVerarbeitet: %d, wartend: %d, verworfen: %d",
+					st.Connected, lastEvent, s.Processed, s.Waiting, s.Dropped+st.Dropped)
+
+				rl := limiter.Stats()
+				lines += fmt.Sprintf("\nLimit: %d in der Warteschlange, %d für den nächsten Überblick", rl.Queued, rl.Digest)
+				if !rl.BlockedUntil.IsZero() {
+					lines += ", Twitter blockiert bis " + rl.BlockedUntil.Local().Format("15:04")
+				}
+
+				return lines
+			},
 		}
 
-		png, err := screenshot.Take(diffURL)
+		go a.Run(context.Background())
+	}
+
+	var store *stats.Store
+	if cfg.Stats.File != "" {
+		store, err = stats.Open(cfg.Stats.File, cfg.Stats.UserKey)
 		if err != nil {
-			if errors.Is(err, screenshot.ErrNotInteresting) {
-				log.Printf("[Skip] Seems like no interesting change was made to %s\n", diffURL)
-			} else {
-				log.Printf("[Error] taking screenshot: %s\n", err.Error())
+			panic("opening stats store: " + err.Error())
+		}
+
+		mux.Handle("/stats", store.Handler())
+	}
+
+	var history *digest.History
+	if cfg.Digest.Daily || cfg.Digest.Weekly {
+		history = digest.NewHistory(0)
+
+		if store != nil {
+			restoreHistory(store, history)
+		}
+
+		// Digests are scheduled and only contain data that was already checked, so they don't need the rate limiter
+		// or moderation. Pausing stops them too
+		scheduler := digestScheduler(cfg, history, publisher)
+		scheduler.Controls = controls
+		go scheduler.Run(context.Background())
+	}
+
+	p.OnDecision = func(d pipeline.Decision) {
+		switch {
+		case d.Outcome == pipeline.OutcomeFailed && d.Reason == pipeline.StageRender:
+			checker.RenderFailed()
+		case d.Item.Image != nil || d.Reason == pipeline.ReasonNotInteresting:
+			checker.RenderSucceeded()
+		}
+
+		dash.Record(d)
+
+		if history != nil {
+			history.Record(d)
+		}
+
+		if store != nil {
+			store.Record(d)
+		}
+	}
+
+	go func() {
+		for range time.Tick(time.Hour) {
+			s := pool.Stats()
+			st := source.Streamer.Stats()
+
+			logger.Info("stats",
+				"queued", s.Queued, "processed", s.Processed, "waiting", s.Waiting, "pool_dropped", s.Dropped,
+				"blocked", s.Blocked, "blocked_time", s.BlockedTime,
+				"stream_dropped", st.Dropped, "spilled", st.Spilled, "spilling", st.Spilling)
+		}
+	}()
+
+	checker.WatchEvents(func() time.Time {
+		return source.Streamer.Stats().LastEvent
+	})
+
+	pool.Run(context.Background(), source)
+}
+
+// talkPageFilter returns the filter for talk pages with the configured limits
+func talkPageFilter(cfg config.Config) *pipeline.TalkPageFilter {
+	f := &pipeline.TalkPageFilter{
+		MinSize:     cfg.TalkPages.MinSize,
+		MinInterval: cfg.TalkPages.MinInterval,
+		MaxPerHour:  cfg.TalkPages.MaxPerHour,
+	}
+	if f.MinSize <= 0 {
+		f.MinSize = 500
+	}
+	if f.MinInterval <= 0 {
+		f.MinInterval = 6 * time.Hour
+	}
+	if f.MaxPerHour <= 0 {
+		f.MaxPerHour = 4
+	}
+	return f
+}
+
+// rateLimiter returns a rate limiter for publisher with the configured limits
+func rateLimiter(cfg config.Config, client *twitter.Client, publisher pipeline.Publisher) *pipeline.RateLimiter {
+	rc := cfg.RateLimit
+
+	r := &pipeline.RateLimiter{
+		Publisher:            publisher,
+		Global:               pipeline.RateLimit{PerHour: rc.Global.PerHour, Burst: rc.Global.Burst},
+		PerArticle:           pipeline.RateLimit{PerHour: rc.PerArticle.PerHour, Burst: rc.PerArticle.Burst},
+		Overflow:             rc.Overflow,
+		QueueSize:            rc.QueueSize,
+		DigestInterval:       rc.DigestInterval,
+		Client:               client,
+		TwitterCheckInterval: rc.TwitterCheckInterval,
+	}
+	if r.Global.PerHour <= 0 {
+		r.Global.PerHour = 30
+	}
+	if r.Global.Burst <= 0 {
+		r.Global.Burst = 5
+	}
+	if r.PerArticle.PerHour <= 0 {
+		r.PerArticle.PerHour = 3
+	}
+	if r.PerArticle.Burst <= 0 {
+		r.PerArticle.Burst = 2
+	}
+
+	switch r.Overflow {
+	case "":
+		r.Overflow = pipeline.LimitQueue
+	case pipeline.LimitDrop, pipeline.LimitQueue, pipeline.LimitDigest:
+	default:
+		panic("unknown rate limit overflow " + r.Overflow)
+	}
+
+	return r
+}
+
+// digestScheduler returns the scheduler for the configured digests
+func digestScheduler(cfg config.Config, history *digest.History, publisher pipeline.Publisher) *digest.Scheduler {
+	s := &digest.Scheduler{
+		History:   history,
+		Publisher: publisher,
+		Daily:     cfg.Digest.Daily,
+		Weekly:    cfg.Digest.Weekly,
+		Weekday:   time.Sunday,
+		At:        20 * time.Hour,
+		Top:       cfg.Digest.Top,
+	}
+
+	if cfg.Digest.Weekday != "" {
+		var found bool
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), cfg.Digest.Weekday) {
+				s.Weekday, found = d, true
 			}
-			continue
 		}
+		if !found {
+			panic("unknown digest weekday " + cfg.Digest.Weekday)
+		}
+	}
 
-		media, _, err := client.Media.Upload(png, "image/png")
+	if cfg.Digest.Time != "" {
+		t, err := time.Parse("15:04", cfg.Digest.Time)
 		if err != nil {
-			log.Printf("[Error] uploading image: %s\n", err.Error())
-			continue
+			panic("parsing digest time: " + err.Error())
 		}
+		s.At = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
 
-		var nameText string
-		switch {
-		case poli.FirstName == "" && poli.LastName != "":
-			nameText = util.Hashtag(poli.LastName)
-		case poli.FirstName != "" && poli.LastName != "":
-			nameText = poli.FirstName + " " + util.Hashtag(poli.LastName)
-		case poli.Name != "":
-			nameText = poli.Name
-		default:
-			log.Printf("[Skip] Couldn't find a name for politician %#v\n", poli)
-			// data doesn't have a name, shouldn't really happen?
-			continue
+	if cfg.Digest.Chart {
+		s.Chart = screenshot.HTML
+	}
+
+	return s
+}
+
+// restoreHistory adds the edits of the last days from the stats store to the digest history,
+// so a restart doesn't lose the edits that happened before it
+func restoreHistory(store *stats.Store, history *digest.History) {
+	var count int
+
+	err := store.Each(time.Now().AddDate(0, 0, -8), func(r stats.Record) {
+		if r.Kind != stats.KindPolitician || r.TalkPage || (r.Type != wikipedia.TypeEdit && r.Type != wikipedia.TypeNew) {
+			return
+		}
+
+		var postURL string
+		if len(r.Posts) > 0 {
+			postURL = r.Posts[0]
 		}
 
-		var tweetText = fmt.Sprintf("Änderung beim Wiki-Eintrag zu %s\n%s", nameText, diffURL)
+		history.Add(r.Time, r.Title, r.Name, r.Party, r.Bytes(), postURL)
+		count++
+	})
+	if err != nil {
+		panic("restoring digest history: " + err.Error())
+	}
+
+	logging.Info("restored digest history from stats store", "component", "main", "edits", count)
+}
 
-		// If we tweeted about this in the last two hours, add it in a thread
-		var replyID int64
-		if li := lastTweetInfo[edit.Title]; time.Since(li.Time) < 2*time.Hour {
-			replyID = li.TweetID
-			tweetText = fmt.Sprintf("Noch eine Änderung bei %s\n%s", nameText, diffURL)
+// queryStats runs the query on the stats store and writes the result as table to stdout
+func queryStats(cfg config.Config, query string) {
+	if cfg.Stats.File == "" {
+		panic("querying stats: no stats file configured")
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		panic("parsing stats query: " + err.Error())
+	}
+
+	q, err := stats.ParseQuery(params)
+	if err != nil {
+		panic("parsing stats query: " + err.Error())
+	}
+
+	store, err := stats.Open(cfg.Stats.File, cfg.Stats.UserKey)
+	if err != nil {
+		panic("opening stats store: " + err.Error())
+	}
+	defer store.Close()
+
+	// Only the operator can look up editors by name, as that needs the key
+	if q.User != "" && !stats.IsPseudonym(q.User) {
+		q.User = store.Pseudonym(q.User)
+	}
+
+	rows, err := store.Query(q)
+	if err != nil {
+		panic("querying stats: " + err.Error())
+	}
+
+	err = stats.WriteTable(os.Stdout, q, rows)
+	if err != nil {
+		panic("writing stats: " + err.Error())
+	}
+}
+
+// export writes all politicians in the given format to stdout
+func export(format string) {
+	poliStore, err := wikidata.Politicians()
+	if err != nil {
+		panic("fetching politicians: " + err.Error())
+	}
+
+	switch format {
+	case "json":
+		err = poliStore.WriteJSON(os.Stdout)
+	case "csv":
+		err = poliStore.WriteCSV(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		panic("exporting politicians: " + err.Error())
+	}
+}
+
+// lookup writes the politicians or organizations that match the query as JSON to stdout, e.g. to find out why an article isn't watched
+func lookup(query string) {
+	var (
+		result interface{}
+		prefix = strings.SplitN(query, ":", 2)
+	)
+
+	if len(prefix) == 2 && prefix[0] == "org" {
+		orgStore, err := wikidata.Organizations()
+		if err != nil {
+			panic("fetching organizations: " + err.Error())
 		}
 
-		t, _, err := client.Statuses.Update(tweetText, &twitter.StatusUpdateParams{
-			MediaIds:          []int64{media.MediaID},
-			InReplyToStatusID: replyID,
-		})
+		result = orgStore.Search(prefix[1])
+	} else {
+		poliStore, err := wikidata.Politicians()
 		if err != nil {
-			log.Printf("[Error] sending tweet: %s\n", err.Error())
-			continue
+			panic("fetching politicians: " + err.Error())
 		}
 
-		// Save this info for the next tweet
-		lastTweetInfo[edit.Title] = lastInfo{
-			TweetID: t.ID,
-			Time:    time.Now(),
+		switch {
+		case len(prefix) == 2 && prefix[0] == "party":
+			result = poliStore.ByParty(prefix[1])
+		case len(prefix) == 2 && prefix[0] == "position":
+			result = poliStore.ByPosition(prefix[1])
+		case qidRegex.MatchString(query):
+			var found []wikidata.Politician
+			if p, ok := poliStore.ByID(query); ok {
+				found = append(found, p)
+			}
+			result = found
+		default:
+			result = poliStore.Search(query)
 		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(result)
+	if err != nil {
+		panic("writing lookup result: " + err.Error())
+	}
+}
 
-		log.Printf("[Tweet] Posted https://twitter.com/%s/status/%s\n", user.ScreenName, t.IDStr)
+var qidRegex = regexp.MustCompile(`^Q\d+$`)
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,381 @@
+// Package metrics implements counters, gauges and histograms that can be scraped by Prometheus.
+// It only supports what the bot needs, see https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry contains metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Default is the registry that is used by the New* functions
+var Default = NewRegistry()
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// register adds the metric. Names must be unique, registering a name twice is a programming error
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " is already registered")
+	}
+	r.metrics[name] = m
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	var names = make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	var metrics = make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Handler returns the handler that serves the default registry
+func Handler() http.Handler {
+	return Default
+}
+
+// family contains all series of a metric with the same name, e.g. one per label value
+type family struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.Mutex
+	series map[string]interface{}
+	keys   []string
+}
+
+func newFamily(name, help, typ string, labels []string) *family {
+	return &family{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]interface{}),
+	}
+}
+
+// get returns the series for the label values, creating it with create if it doesn't exist yet
+func (f *family) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+
+	var pairs = make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + `="` + escapeLabel(v) + `"`
+	}
+	key := strings.Join(pairs, ",")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = create()
+		f.series[key] = s
+		f.keys = append(f.keys, key)
+		sort.Strings(f.keys)
+	}
+
+	return s
+}
+
+func (f *family) write(w io.Writer, writeSeries func(w io.Writer, name, labels string, s interface{})) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, key := range f.keys {
+		writeSeries(w, f.name, key, f.series[key])
+	}
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter. Negative values are ignored
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.value
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	f *family
+}
+
+// NewCounter registers a counter without labels in the default registry
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter with the given labels in the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{f: newFamily(name, help, "counter", labels)}
+	Default.register(name, c)
+	return c
+}
+
+// With returns the counter for the label values, which must be in the same order as the labels
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.f.get(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.f.write(w, func(w io.Writer, name, labels string, s interface{}) {
+		writeSample(w, name, labels, s.(*Counter).Value())
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Add adds v to the gauge, v can be negative
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.value
+}
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct {
+	f *family
+}
+
+// NewGauge registers a gauge without labels in the default registry
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+// NewGaugeVec registers a gauge with the given labels in the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{f: newFamily(name, help, "gauge", labels)}
+	Default.register(name, g)
+	return g
+}
+
+// With returns the gauge for the label values, which must be in the same order as the labels
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.f.get(values, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.f.write(w, func(w io.Writer, name, labels string, s interface{}) {
+		writeSample(w, name, labels, s.(*Gauge).Value())
+	})
+}
+
+// gaugeFunc is a gauge whose value is computed when it is scraped
+type gaugeFunc struct {
+	f     *family
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge in the default registry that calls value every time it's scraped
+func NewGaugeFunc(name, help string, value func() float64) {
+	Default.register(name, &gaugeFunc{
+		f:     newFamily(name, help, "gauge", nil),
+		value: value,
+	})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.f.name, escapeHelp(g.f.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.f.name)
+	writeSample(w, g.f.name, "", g.value())
+}
+
+// Histogram counts observations in buckets, e.g. for durations
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	f       *family
+	buckets []float64
+}
+
+// DurationBuckets are buckets in seconds that fit operations between a few milliseconds and a few minutes
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// NewHistogram registers a histogram without labels in the default registry. buckets are the upper bounds, sorted ascending
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec registers a histogram with the given labels in the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		f:       newFamily(name, help, "histogram", labels),
+		buckets: buckets,
+	}
+	Default.register(name, h)
+	return h
+}
+
+// With returns the histogram for the label values, which must be in the same order as the labels
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.f.get(values, func() interface{} {
+		return &Histogram{
+			buckets: h.buckets,
+			counts:  make([]uint64, len(h.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.f.write(w, func(w io.Writer, name, labels string, s interface{}) {
+		hist := s.(*Histogram)
+
+		hist.mu.Lock()
+		defer hist.mu.Unlock()
+
+		for i, b := range hist.buckets {
+			writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(b)+`"`), float64(hist.counts[i]))
+		}
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(hist.count))
+		writeSample(w, name+"_sum", labels, hist.sum)
+		writeSample(w, name+"_count", labels, float64(hist.count))
+	})
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,186 @@
+package moderation
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(templateFS, "templates/index.html"))
+
+// Handler serves the moderation page and API. If user and password are not empty, they are required
+// with HTTP basic auth. Use http.StripPrefix if it should be served below "/".
+//
+//	GET  /                  page with all pending posts
+//	GET  /entries           pending posts as JSON
+//	GET  /screenshot/<id>   image of a pending post
+//	POST /approve/<id>      publish the post, the form values "text" and "followup_text" replace the proposed texts if they were changed
+//	POST /reject/<id>       drop the post
+//
+// Approving and rejecting redirects back to the page, or returns JSON if the request accepts it.
+// Both need the CSRF token, either as form value "csrf" or as header X-CSRF-Token. It's part of the
+// page and returned in the X-CSRF-Token header of GET /entries
+func (q *Queue) Handler(user, password string) http.Handler {
+	var token = make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		panic("generating CSRF token: " + err.Error())
+	}
+
+	return &handler{
+		q:        q,
+		user:     user,
+		password: password,
+		csrf:     hex.EncodeToString(token),
+	}
+}
+
+type handler struct {
+	q              *Queue
+	user, password string
+
+	// csrf must be sent with every POST request, so other sites can't make the browser of a moderator approve posts
+	csrf string
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.user != "" || h.password != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(h.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="poliwiki moderation"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var path = strings.TrimPrefix(r.URL.Path, "/")
+
+	if r.Method == http.MethodPost && !h.validCSRF(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		h.index(w, r)
+	case path == "entries" && r.Method == http.MethodGet:
+		w.Header().Set("X-CSRF-Token", h.csrf)
+		writeJSON(w, http.StatusOK, h.q.Pending())
+	case strings.HasPrefix(path, "screenshot/") && r.Method == http.MethodGet:
+		h.screenshot(w, r, strings.TrimPrefix(path, "screenshot/"))
+	case strings.HasPrefix(path, "approve/") && r.Method == http.MethodPost:
+		h.approve(w, r, strings.TrimPrefix(path, "approve/"))
+	case strings.HasPrefix(path, "reject/") && r.Method == http.MethodPost:
+		h.reject(w, r, strings.TrimPrefix(path, "reject/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := indexTemplate.Execute(w, map[string]interface{}{
+		"Entries": h.q.Pending(),
+		"Error":   r.URL.Query().Get("error"),
+		"CSRF":    h.csrf,
+	})
+	if err != nil {
+		logger.Error("rendering moderation page failed", "err", err)
+	}
+}
+
+func (h *handler) screenshot(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(strings.TrimSuffix(idStr, ".png"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	e, ok := h.q.Get(id)
+	if !ok || len(e.Post.Image) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(e.Post.Image)
+}
+
+func (h *handler) approve(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	pub, err := h.q.Approve(r.Context(), id, formText(r, "text"), formText(r, "followup_text"))
+	h.respond(w, r, pub, err)
+}
+
+func (h *handler) reject(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.respond(w, r, nil, h.q.Reject(id))
+}
+
+func (h *handler) respond(w http.ResponseWriter, r *http.Request, result interface{}, err error) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		switch {
+		case errors.Is(err, pipeline.ErrDeferred):
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": err.Error()})
+		case errors.Is(err, ErrNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, result)
+		}
+		return
+	}
+
+	// Forms are posted from the page, so we go back there. http.Redirect would resolve the
+	// relative URL against the path after http.StripPrefix, so the browser has to do it
+	var target = "../"
+	if err != nil && !errors.Is(err, pipeline.ErrDeferred) {
+		target += "?error=" + template.URLQueryEscaper(err.Error())
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func (h *handler) validCSRF(r *http.Request) bool {
+	var token = r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.csrf)) == 1
+}
+
+// formText returns the trimmed form value. Browsers send line breaks in text areas as "\r\n", so they are normalized
+func formText(r *http.Request, name string) string {
+	return strings.TrimSpace(strings.ReplaceAll(r.FormValue(name), "\r\n", "\n"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
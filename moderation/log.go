@@ -0,0 +1,5 @@
+package moderation
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "moderation")
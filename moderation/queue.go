@@ -0,0 +1,272 @@
+// Package moderation holds back posts until a moderator approved them. Low-risk posts can be approved automatically
+package moderation
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/logging"
+	"github.com/xarantolus/poliwiki/pipeline"
+)
+
+// ErrNotFound is returned if there's no pending entry with the given ID, e.g. because it was already approved
+var ErrNotFound = errors.New("no pending post with this ID")
+
+// Kinds of subjects for rules
+const (
+	SubjectPolitician   = "politician"
+	SubjectOrganization = "organization"
+	SubjectTalkPage     = "talk"
+)
+
+// Rule describes low-risk posts that are approved automatically. All conditions that are set must match
+type Rule struct {
+	// Types are event types, e.g. "categorize"
+	Types []string
+	// Subjects are SubjectPolitician, SubjectOrganization or SubjectTalkPage
+	Subjects []string
+	// MaxSizeDifference is the maximum number of characters that were added or removed. Zero means no limit
+	MaxSizeDifference int
+}
+
+func (r Rule) matches(item *pipeline.Item) bool {
+	if len(r.Types) > 0 && !contains(r.Types, item.Event.Type) {
+		return false
+	}
+
+	if len(r.Subjects) > 0 && !contains(r.Subjects, subjectKind(item)) {
+		return false
+	}
+
+	if r.MaxSizeDifference > 0 && item.Event.SizeDifference() > r.MaxSizeDifference {
+		return false
+	}
+
+	return true
+}
+
+func subjectKind(item *pipeline.Item) string {
+	switch {
+	case item.Event.IsTalkPage():
+		return SubjectTalkPage
+	case item.Subject.Organization != nil:
+		return SubjectOrganization
+	default:
+		return SubjectPolitician
+	}
+}
+
+// Entry is a post that waits for moderation
+type Entry struct {
+	ID      int       `json:"id"`
+	Created time.Time `json:"created"`
+
+	Page    string `json:"page"`
+	Subject string `json:"subject"`
+	Type    string `json:"type"`
+	DiffURL string `json:"diff_url,omitempty"`
+
+	// Reasons why this post could be problematic, e.g. a possible self-edit
+	Notes []string `json:"notes,omitempty"`
+
+	Post pipeline.Post `json:"-"`
+
+	// Text is the proposed text of the post. FollowupText is only set if the post has a different
+	// text for when it's added to an existing thread
+	Text         string `json:"text"`
+	FollowupText string `json:"followup_text,omitempty"`
+	HasImage     bool   `json:"has_image"`
+
+	log *logging.Logger
+}
+
+// Queue implements pipeline.Moderator. Posts that don't match any rule wait until they are approved or rejected
+type Queue struct {
+	publisher pipeline.Publisher
+	rules     []Rule
+	maxAge    time.Duration
+
+	mu      sync.Mutex
+	entries map[int]*Entry
+	nextID  int
+}
+
+// New returns a queue that publishes approved posts with publisher. Entries that are older than maxAge
+// are dropped, it defaults to one day. Posts are only kept in memory, so pending ones are lost on restart
+func New(publisher pipeline.Publisher, rules []Rule, maxAge time.Duration) *Queue {
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+
+	return &Queue{
+		publisher: publisher,
+		rules:     rules,
+		maxAge:    maxAge,
+		entries:   make(map[int]*Entry),
+	}
+}
+
+// Moderate holds back the post unless it matches one of the rules
+func (q *Queue) Moderate(ctx context.Context, item *pipeline.Item, post pipeline.Post) (held bool, err error) {
+	for _, r := range q.rules {
+		if r.matches(item) {
+			item.Log.Debug("approved automatically")
+			return false, nil
+		}
+	}
+
+	e := &Entry{
+		Created:  time.Now(),
+		Page:     item.Page,
+		Subject:  item.Subject.Name,
+		Type:     item.Event.Type,
+		DiffURL:  item.DiffURL,
+		Post:     post,
+		Text:     post.Text,
+		HasImage: len(post.Image) > 0,
+		log:      item.Log,
+	}
+	if post.FollowupText != post.Text {
+		e.FollowupText = post.FollowupText
+	}
+	if item.Attribution != nil {
+		e.Notes = append(e.Notes, item.Attribution.Text)
+	}
+	if item.Conflict != nil {
+		e.Notes = append(e.Notes, item.Conflict.Text())
+	}
+
+	q.mu.Lock()
+	q.cleanup()
+	q.nextID++
+	e.ID = q.nextID
+	q.entries[e.ID] = e
+	q.mu.Unlock()
+
+	item.Log.Info("waiting for moderation", "moderation_id", e.ID)
+
+	return true, nil
+}
+
+// Pending returns all entries that wait for moderation, oldest first
+func (q *Queue) Pending() (entries []Entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.cleanup()
+
+	for _, e := range q.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return
+}
+
+// Get returns the pending entry with the given ID
+func (q *Queue) Get(id int) (e Entry, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ep, ok := q.entries[id]
+	if !ok {
+		return
+	}
+	return *ep, true
+}
+
+// Approve publishes the post. text and followupText replace the proposed texts if they are not empty and
+// were changed, the other one is kept. If the post has no extra followup text, a changed text is used for both.
+// If publishing fails, the entry stays in the queue. An error that wraps pipeline.ErrDeferred
+// means the post was approved, but is published later
+func (q *Queue) Approve(ctx context.Context, id int, text, followupText string) (pub pipeline.Published, err error) {
+	e, ok := q.take(id)
+	if !ok {
+		return pub, ErrNotFound
+	}
+
+	var (
+		post   = e.Post
+		edited bool
+	)
+	if text != "" && text != strings.TrimSpace(post.Text) {
+		// Without its own followup text, the post uses Text in threads too
+		if post.FollowupText == "" || post.FollowupText == post.Text {
+			post.FollowupText = text
+		}
+		post.Text = text
+		edited = true
+	}
+	if followupText != "" && e.FollowupText != "" && followupText != strings.TrimSpace(e.FollowupText) {
+		post.FollowupText = followupText
+		edited = true
+	}
+
+	pub, err = q.publisher.Publish(ctx, post)
+	if errors.Is(err, pipeline.ErrDeferred) {
+		// The publisher takes care of it now, so it must not be approved again
+		e.log.Info("approved, will be posted later", "moderation_id", id, "detail", err)
+		return
+	}
+	if err != nil {
+		e.log.Error("publishing approved post failed", "moderation_id", id, "err", err)
+
+		q.mu.Lock()
+		q.entries[id] = e
+		q.mu.Unlock()
+
+		return
+	}
+
+	e.log.Info("posted", "moderation_id", id, "url", pub.URL, "in_reply_to", pub.InReplyTo, "edited", edited)
+
+	return
+}
+
+// Reject removes the entry without publishing it
+func (q *Queue) Reject(id int) error {
+	e, ok := q.take(id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	e.log.Info("rejected by moderator", "moderation_id", id)
+
+	return nil
+}
+
+// take removes the entry, so it can't be approved twice at the same time
+func (q *Queue) take(id int) (e *Entry, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok = q.entries[id]
+	delete(q.entries, id)
+
+	return
+}
+
+// cleanup removes entries that are too old. The lock must be held
+func (q *Queue) cleanup() {
+	for id, e := range q.entries {
+		if time.Since(e.Created) > q.maxAge {
+			e.log.Info("dropping post that wasn't moderated in time", "moderation_id", id)
+			delete(q.entries, id)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
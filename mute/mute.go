@@ -0,0 +1,425 @@
+// Package mute keeps the list of articles and editors that should not be posted about.
+// Entries come from the configuration or are added at runtime, the latter are stored in a file
+package mute
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/config"
+)
+
+// Kinds of entries
+const (
+	// KindArticle mutes an article by its title
+	KindArticle = "article"
+	// KindQID mutes a politician by their WikiData ID, e.g. "Q567"
+	KindQID = "qid"
+	// KindUser mutes all edits of a registered user
+	KindUser = "user"
+	// KindRange mutes all edits from an IP range
+	KindRange = "range"
+)
+
+// Entry is something that is muted
+type Entry struct {
+	Kind  string
+	Value string
+
+	// Until is the time the mute ends, zero means forever
+	Until time.Time
+
+	Reason string
+
+	// Configured is true for entries from the configuration, they can't be removed
+	Configured bool
+
+	ipnet *net.IPNet
+}
+
+// String describes the entry, e.g. "Artikel Max Mustermann (bis 01.01.2022 12:00)"
+func (e Entry) String() string {
+	var s string
+	switch e.Kind {
+	case KindArticle:
+		s = "Artikel " + e.Value
+	case KindQID:
+		s = "WikiData " + e.Value
+	case KindUser:
+		s = "Benutzer " + e.Value
+	case KindRange:
+		s = "IP-Bereich " + e.Value
+	default:
+		s = e.Kind + " " + e.Value
+	}
+
+	if !e.Until.IsZero() {
+		s += " (bis " + e.Until.Local().Format("02.01.2006 15:04") + ")"
+	}
+
+	return s
+}
+
+// active returns whether the entry hasn't ended at the given time
+func (e Entry) active(now time.Time) bool {
+	return e.Until.IsZero() || now.Before(e.Until)
+}
+
+// same returns whether both entries mute the same thing
+func (e Entry) same(o Entry) bool {
+	return e.Kind == o.Kind && normalize(e.Kind, e.Value) == normalize(o.Kind, o.Value)
+}
+
+var qidRegex = regexp.MustCompile(`^[Qq][0-9]+$`)
+
+// Parse parses an entry from a command. "Q567" is a QID, IP addresses and ranges in CIDR notation are ranges,
+// "user:Name" or "Benutzer:Name" is a user and everything else is the title of an article
+func Parse(s string) (e Entry, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return e, errors.New("nothing to mute")
+	}
+
+	switch {
+	case qidRegex.MatchString(s):
+		e = Entry{Kind: KindQID, Value: strings.ToUpper(s)}
+	case net.ParseIP(s) != nil || strings.Contains(s, "/") && isCIDR(s):
+		e = Entry{Kind: KindRange, Value: s}
+	case hasPrefixFold(s, "user:"), hasPrefixFold(s, "benutzer:"):
+		e = Entry{Kind: KindUser, Value: strings.TrimSpace(s[strings.Index(s, ":")+1:])}
+	default:
+		e = Entry{Kind: KindArticle, Value: s}
+	}
+
+	return e, e.init()
+}
+
+func isCIDR(s string) bool {
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// init validates the entry and parses ranges
+func (e *Entry) init() error {
+	e.Value = strings.TrimSpace(e.Value)
+	if e.Value == "" {
+		return fmt.Errorf("empty %s", e.Kind)
+	}
+
+	switch e.Kind {
+	case KindArticle, KindQID, KindUser:
+		return nil
+	case KindRange:
+		r := e.Value
+		if ip := net.ParseIP(r); ip != nil {
+			// A single address is a range that only contains it
+			if ip.To4() != nil {
+				r += "/32"
+			} else {
+				r += "/128"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(r)
+		if err != nil {
+			return fmt.Errorf("parsing range %q: %w", e.Value, err)
+		}
+		e.ipnet = ipnet
+
+		return nil
+	default:
+		return fmt.Errorf("unknown kind %q", e.Kind)
+	}
+}
+
+// normalize makes values comparable. MediaWiki treats underscores like spaces, WikiData IDs are upper case
+func normalize(kind, value string) string {
+	value = strings.TrimSpace(value)
+
+	switch kind {
+	case KindArticle, KindUser:
+		return strings.ToLower(strings.ReplaceAll(value, "_", " "))
+	case KindQID:
+		return strings.ToUpper(value)
+	default:
+		return value
+	}
+}
+
+// List is the list of muted things. It's safe for concurrent use
+type List struct {
+	file string
+
+	mu         sync.Mutex
+	configured []Entry
+	entries    []Entry
+}
+
+// New returns the list with the entries from the configuration and the ones stored in the configured file
+func New(cfg config.Config) (l *List, err error) {
+	l = &List{
+		file: cfg.Mute.File,
+	}
+
+	for i, c := range cfg.Mute.Entries {
+		var e = Entry{
+			Until:      c.Until,
+			Reason:     c.Reason,
+			Configured: true,
+		}
+
+		var set int
+		for kind, value := range map[string]string{KindArticle: c.Article, KindQID: c.QID, KindUser: c.User, KindRange: c.Range} {
+			if value != "" {
+				e.Kind, e.Value = kind, value
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("mute entry %d: exactly one of article, qid, user and range must be set", i+1)
+		}
+
+		err = e.init()
+		if err != nil {
+			return nil, fmt.Errorf("mute entry %d: %w", i+1, err)
+		}
+
+		l.configured = append(l.configured, e)
+	}
+
+	err = l.load()
+	if err != nil {
+		return nil, fmt.Errorf("loading muted entries from %q: %w", l.file, err)
+	}
+
+	return
+}
+
+// Match returns the first active entry that matches the article, the WikiData ID of the politician or the user
+// who made the edit. Any of them can be empty
+func (l *List) Match(title, qid, user string) (e Entry, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		now  = time.Now()
+		ip   = net.ParseIP(user)
+		vals = map[string]string{
+			KindArticle: normalize(KindArticle, title),
+			KindQID:     normalize(KindQID, qid),
+			KindUser:    normalize(KindUser, user),
+		}
+	)
+
+	for _, list := range [][]Entry{l.configured, l.entries} {
+		for _, e := range list {
+			if !e.active(now) {
+				continue
+			}
+
+			if e.Kind == KindRange {
+				if ip != nil && e.ipnet.Contains(ip) {
+					return e, true
+				}
+				continue
+			}
+
+			if v := vals[e.Kind]; v != "" && v == normalize(e.Kind, e.Value) {
+				return e, true
+			}
+		}
+	}
+
+	return
+}
+
+// Add adds the entry, or updates it if the same thing is already muted. The list is saved right away
+func (l *List) Add(e Entry) error {
+	err := e.init()
+	if err != nil {
+		return err
+	}
+	e.Configured = false
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup()
+
+	var replaced bool
+	for i, o := range l.entries {
+		if o.same(e) {
+			l.entries[i] = e
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		l.entries = append(l.entries, e)
+	}
+
+	return l.save()
+}
+
+// Remove removes the entry. ok is false if the thing wasn't muted with Add, entries from
+// the configuration can't be removed
+func (l *List) Remove(e Entry) (ok bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup()
+
+	for i, o := range l.entries {
+		if o.same(e) {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			return true, l.save()
+		}
+	}
+
+	return false, nil
+}
+
+// Clear removes all entries that were added with Add
+func (l *List) Clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+
+	return l.save()
+}
+
+// Entries returns all active entries, the ones from the configuration first
+func (l *List) Entries() (entries []Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, list := range [][]Entry{l.configured, l.entries} {
+		for _, e := range list {
+			if e.active(now) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	return
+}
+
+// cleanup removes entries that have ended. The lock must be held
+func (l *List) cleanup() {
+	var (
+		now     = time.Now()
+		entries []Entry
+	)
+	for _, e := range l.entries {
+		if e.active(now) {
+			entries = append(entries, e)
+		}
+	}
+	l.entries = entries
+}
+
+// fileEntry is how entries are stored in the file
+type fileEntry struct {
+	Kind   string     `json:"kind"`
+	Value  string     `json:"value"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// load reads the entries from the file. A missing file is not an error
+func (l *List) load() error {
+	if l.file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []fileEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	for _, fe := range entries {
+		var e = Entry{
+			Kind:   fe.Kind,
+			Value:  fe.Value,
+			Reason: fe.Reason,
+		}
+		if fe.Until != nil {
+			e.Until = *fe.Until
+		}
+
+		err = e.init()
+		if err != nil {
+			return err
+		}
+		l.entries = append(l.entries, e)
+	}
+	l.cleanup()
+
+	return nil
+}
+
+// save writes the entries to the file. The lock must be held
+func (l *List) save() error {
+	if l.file == "" {
+		return nil
+	}
+
+	var entries = []fileEntry{}
+	for _, e := range l.entries {
+		fe := fileEntry{
+			Kind:   e.Kind,
+			Value:  e.Value,
+			Reason: e.Reason,
+		}
+		if !e.Until.IsZero() {
+			until := e.Until
+			fe.Until = &until
+		}
+		entries = append(entries, fe)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so we don't end up with half a file if something goes wrong
+	tmp, err := os.CreateTemp(filepath.Dir(l.file), filepath.Base(l.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), l.file)
+}
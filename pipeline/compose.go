@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// TextComposer creates the german texts of posts
+type TextComposer struct{}
+
+func (TextComposer) Compose(item *Item) (post Post, err error) {
+	post = Post{
+		ThreadKey: item.Page,
+		Image:     item.Image,
+	}
+
+	var e = &item.Event
+
+	// Talk pages, page creations, deletions, protections and category changes have their own texts
+	switch {
+	case e.IsTalkPage():
+		post.Text, err = talkText(item)
+	case e.Type != wikipedia.TypeEdit:
+		post.Text, err = eventText(item)
+	default:
+		newTemplate, followupTemplate := templates(item.Subject)
+
+		var extra string
+		if item.Attribution != nil {
+			extra += "\n" + item.Attribution.Text
+		}
+		if item.Conflict != nil {
+			extra += "\n" + item.Conflict.Text()
+		}
+		extra += "\n" + item.DiffURL
+
+		post.Text = fmt.Sprintf(newTemplate, item.Subject.Name) + extra
+		post.FollowupText = fmt.Sprintf(followupTemplate, item.Subject.Name) + extra
+	}
+
+	return
+}
+
+// templates returns the texts for the first edit and any further edits of an article.
+// Both take the name as argument
+func templates(s Subject) (newTemplate, followupTemplate string) {
+	if s.Organization == nil {
+		return "Änderung beim Wiki-Eintrag zu %s", "Noch eine Änderung bei %s"
+	}
+
+	switch s.Organization.Kind {
+	case wikidata.KindParty:
+		return "Änderung beim Wiki-Eintrag der Partei %s", "Noch eine Änderung bei der Partei %s"
+	case wikidata.KindParliament:
+		return "Änderung beim Wiki-Eintrag zum Parlament %s", "Noch eine Änderung beim Parlament %s"
+	case wikidata.KindMinistry:
+		return "Änderung beim Wiki-Eintrag zum Ministerium %s", "Noch eine Änderung beim Ministerium %s"
+	default:
+		return "Änderung beim Wiki-Eintrag zu %s", "Noch eine Änderung bei %s"
+	}
+}
+
+// eventText returns the text for events that are not edits
+func eventText(item *Item) (text string, err error) {
+	var (
+		e        = &item.Event
+		nameText = item.Subject.Name
+	)
+
+	switch {
+	case e.Type == wikipedia.TypeNew:
+		u, ok := e.RevisionURL()
+		if !ok {
+			u = e.PageURL()
+		}
+		return fmt.Sprintf("Neuer Wiki-Eintrag zu %s\n%s", nameText, u), nil
+	case e.IsDeletion():
+		return fmt.Sprintf("Der Wiki-Eintrag zu %s wurde gelöscht\n%s", nameText, e.LogURL()), nil
+	case e.IsProtection():
+		switch e.LogAction {
+		case "protect":
+			text = fmt.Sprintf("Der Wiki-Eintrag zu %s wurde geschützt", nameText)
+		case "modify":
+			text = fmt.Sprintf("Der Schutz des Wiki-Eintrags zu %s wurde geändert", nameText)
+		case "unprotect":
+			return fmt.Sprintf("Der Schutz des Wiki-Eintrags zu %s wurde aufgehoben\n%s", nameText, e.LogURL()), nil
+		default:
+			return "", skipf(ReasonEventType, "not posting protection log action %q for %q", e.LogAction, item.Page)
+		}
+		if e.LogParams.Description != "" {
+			text += ":\n" + e.LogParams.Description
+		}
+		return text + "\n" + e.LogURL(), nil
+	case e.Type == wikipedia.TypeCategorize:
+		if e.CategoryRemoved() {
+			return fmt.Sprintf("Der Wiki-Eintrag zu %s wurde aus der Kategorie „%s“ entfernt\n%s", nameText, e.Category(), e.PageURL()), nil
+		}
+		return fmt.Sprintf("Der Wiki-Eintrag zu %s wurde zur Kategorie „%s“ hinzugefügt\n%s", nameText, e.Category(), e.PageURL()), nil
+	default:
+		return "", skipf(ReasonEventType, "not posting %s event (%s/%s) for %q", e.Type, e.LogType, e.LogAction, item.Page)
+	}
+}
+
+// talkText returns the text for changes to talk pages
+func talkText(item *Item) (text string, err error) {
+	var (
+		e        = &item.Event
+		nameText = item.Subject.Name
+	)
+
+	if e.Type == wikipedia.TypeNew {
+		return fmt.Sprintf("Neue Diskussionsseite zum Wiki-Eintrag zu %s\n%s", nameText, e.PageURL()), nil
+	}
+
+	if section, ok := e.NewSection(); ok {
+		return fmt.Sprintf("Neuer Abschnitt „%s“ auf der Diskussionsseite zu %s\n%s", section, nameText, e.SectionURL(section)), nil
+	}
+
+	return fmt.Sprintf("Diskussion zum Wiki-Eintrag zu %s: %+d Zeichen\n%s", nameText, e.Length.New-e.Length.Old, item.DiffURL), nil
+}
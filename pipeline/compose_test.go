@@ -0,0 +1,175 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/xarantolus/poliwiki/attribution"
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+func TestTextComposer(t *testing.T) {
+	var (
+		edit      = streamtest.Edit("Max Mustermann", 100, 1000)
+		diffURL   = "https://de.wikipedia.org/w/index.php?diff=2&oldid=1"
+		newPage   = streamtest.NewPage("Max Mustermann", 1000)
+		deletion  = streamtest.Log("Max Mustermann", wikipedia.LogTypeDelete, "delete")
+		protect   = streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "protect")
+		modify    = streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "modify")
+		unprotect = streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "unprotect")
+		move      = streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "move_prot")
+		rename    = streamtest.Log("Max Mustermann", "move", "move")
+		talkNew   = streamtest.NewPage("Diskussion:Max Mustermann", 100)
+		section   = talkEdit("Max Mustermann", 100, 200, "/* Parteiwechsel */ neuer Abschnitt")
+		talk      = talkEdit("Max Mustermann", 100, 700, "Antwort")
+	)
+	protect.LogParams.Description = "[Bearbeiten=Nur Sichtern erlauben] (unbeschränkt)"
+
+	newPageURL, _ := newPage.RevisionURL()
+	party := spd
+
+	var tests = []struct {
+		name  string
+		event wikipedia.Event
+		setup func(item *pipeline.Item)
+
+		wantText     string
+		wantFollowup string
+		wantReason   string
+	}{
+		{
+			name:         "edit",
+			event:        edit,
+			wantText:     "Änderung beim Wiki-Eintrag zu Max #Mustermann\n" + diffURL,
+			wantFollowup: "Noch eine Änderung bei Max #Mustermann\n" + diffURL,
+		},
+		{
+			name:  "attributed edit",
+			event: edit,
+			setup: func(item *pipeline.Item) {
+				item.Attribution = &attribution.Attribution{Text: "Änderung aus dem Netz des Bundestags"}
+				item.Conflict = &attribution.Conflict{Score: 0.8}
+			},
+			wantText:     "Änderung beim Wiki-Eintrag zu Max #Mustermann\nÄnderung aus dem Netz des Bundestags\nMögliche Eigenbearbeitung (Wahrscheinlichkeit 80 %)\n" + diffURL,
+			wantFollowup: "Noch eine Änderung bei Max #Mustermann\nÄnderung aus dem Netz des Bundestags\nMögliche Eigenbearbeitung (Wahrscheinlichkeit 80 %)\n" + diffURL,
+		},
+		{
+			name:  "party edit",
+			event: edit,
+			setup: func(item *pipeline.Item) {
+				item.Subject = pipeline.Subject{Title: party.WikiPageTitle, Organization: &party, Name: pipeline.OrganizationName(party)}
+			},
+			wantText:     "Änderung beim Wiki-Eintrag der Partei #SPD\n" + diffURL,
+			wantFollowup: "Noch eine Änderung bei der Partei #SPD\n" + diffURL,
+		},
+		{
+			name:     "new page",
+			event:    newPage,
+			wantText: "Neuer Wiki-Eintrag zu Max #Mustermann\n" + newPageURL,
+		},
+		{
+			name:     "deletion",
+			event:    deletion,
+			wantText: "Der Wiki-Eintrag zu Max #Mustermann wurde gelöscht\n" + deletion.LogURL(),
+		},
+		{
+			name:     "protect",
+			event:    protect,
+			wantText: "Der Wiki-Eintrag zu Max #Mustermann wurde geschützt:\n[Bearbeiten=Nur Sichtern erlauben] (unbeschränkt)\n" + protect.LogURL(),
+		},
+		{
+			name:     "modify",
+			event:    modify,
+			wantText: "Der Schutz des Wiki-Eintrags zu Max #Mustermann wurde geändert\n" + modify.LogURL(),
+		},
+		{
+			name:     "unprotect",
+			event:    unprotect,
+			wantText: "Der Schutz des Wiki-Eintrags zu Max #Mustermann wurde aufgehoben\n" + unprotect.LogURL(),
+		},
+		{
+			name:       "other protection action",
+			event:      move,
+			wantReason: pipeline.ReasonEventType,
+		},
+		{
+			name:       "other log type",
+			event:      rename,
+			wantReason: pipeline.ReasonEventType,
+		},
+		{
+			name:     "added to category",
+			event:    categorize("Max Mustermann", false),
+			wantText: "Der Wiki-Eintrag zu Max #Mustermann wurde zur Kategorie „Mitglied des Bayerischen Landtags“ hinzugefügt\nhttps://de.wikipedia.org/wiki/Max_Mustermann",
+		},
+		{
+			name:     "removed from category",
+			event:    categorize("Max Mustermann", true),
+			wantText: "Der Wiki-Eintrag zu Max #Mustermann wurde aus der Kategorie „Mitglied des Bayerischen Landtags“ entfernt\nhttps://de.wikipedia.org/wiki/Max_Mustermann",
+		},
+		{
+			name:     "new talk page",
+			event:    talkNew,
+			wantText: "Neue Diskussionsseite zum Wiki-Eintrag zu Max #Mustermann\nhttps://de.wikipedia.org/wiki/Diskussion:Max_Mustermann",
+		},
+		{
+			name:     "new section",
+			event:    section,
+			wantText: "Neuer Abschnitt „Parteiwechsel“ auf der Diskussionsseite zu Max #Mustermann\n" + section.SectionURL("Parteiwechsel"),
+		},
+		{
+			name:     "talk page edit",
+			event:    talk,
+			wantText: "Diskussion zum Wiki-Eintrag zu Max #Mustermann: +600 Zeichen\n" + diffURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := newItem(tt.event, mustermann)
+			item.DiffURL = diffURL
+			item.Image = []byte("png")
+			if tt.setup != nil {
+				tt.setup(item)
+			}
+
+			post, err := pipeline.TextComposer{}.Compose(item)
+			if tt.wantReason != "" || err != nil {
+				if reason := skipReason(err); reason != tt.wantReason {
+					t.Errorf("got error %v with reason %q, want %q", err, reason, tt.wantReason)
+				}
+				return
+			}
+
+			if post.Text != tt.wantText {
+				t.Errorf("got text\n%s\nwant\n%s", post.Text, tt.wantText)
+			}
+			if post.FollowupText != tt.wantFollowup {
+				t.Errorf("got followup text\n%s\nwant\n%s", post.FollowupText, tt.wantFollowup)
+			}
+			if post.ThreadKey != item.Page || string(post.Image) != "png" {
+				t.Errorf("got thread key %q and image %q, want %q and the item's image", post.ThreadKey, post.Image, item.Page)
+			}
+		})
+	}
+}
+
+func TestOrganizationName(t *testing.T) {
+	var tests = []struct {
+		org  wikidata.Organization
+		want string
+	}{
+		{spd, "#SPD"},
+		{wikidata.Organization{Kind: wikidata.KindParty, Name: "Bündnis 90/Die Grünen", ShortName: "B90/Grüne", Hashtag: "Grüne"}, "#Grüne"},
+		{wikidata.Organization{Kind: wikidata.KindMinistry, Name: "Bundesministerium der Finanzen", ShortName: "BMF"}, "Bundesministerium der Finanzen"},
+		{wikidata.Organization{Kind: wikidata.KindParliament, Name: "Deutscher Bundestag", Hashtag: "Bundestag"}, "#Bundestag"},
+	}
+
+	for _, tt := range tests {
+		if got := pipeline.OrganizationName(tt.org); got != tt.want {
+			t.Errorf("OrganizationName(%q) = %q, want %q", tt.org.Name, got, tt.want)
+		}
+	}
+}
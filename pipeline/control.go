@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/xarantolus/poliwiki/mute"
+)
+
+// Controls allow pausing the pipeline and muting articles while the bot is running, e.g. with admin commands.
+// They are checked before anything else, so observers don't post about paused or muted events either.
+// The zero value is ready to use and not paused
+type Controls struct {
+	// Mutes skips events about muted articles and politicians and edits by muted users and IP ranges, optional
+	Mutes *mute.List
+
+	mu     sync.Mutex
+	paused bool
+}
+
+// Pause stops posting until Resume is called. Events are still received, but skipped
+func (c *Controls) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+}
+
+// Resume continues posting after Pause
+func (c *Controls) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+}
+
+// Paused returns whether posting is paused
+func (c *Controls) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// check returns a *Skip if the item should not be processed
+func (c *Controls) check(item *Item) error {
+	if c.Paused() {
+		return skipf(ReasonPaused, "paused, not posting change to %q", item.Page)
+	}
+
+	if c.Mutes != nil {
+		var qid string
+		if item.Subject.Politician != nil {
+			qid = item.Subject.Politician.ID
+		}
+
+		if e, ok := c.Mutes.Match(item.Subject.Title, qid, item.Event.User); ok {
+			// The value isn't included, decisions end up in logs and on the dashboard and must not name users
+			return skipf(ReasonMuted, "not posting change to %q, matches muted %s", item.Page, e.Kind)
+		}
+	}
+
+	return nil
+}
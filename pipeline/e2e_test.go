@@ -0,0 +1,147 @@
+package pipeline_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/bot/twittertest"
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+// fakeRenderer returns the same image for every item instead of taking a screenshot
+type fakeRenderer struct{}
+
+func (fakeRenderer) Render(ctx context.Context, item *pipeline.Item) (png []byte, err error) {
+	return []byte("\x89PNG " + item.DiffURL), nil
+}
+
+var mustermann = wikidata.Politician{
+	ID:            "Q1",
+	Name:          "Max Mustermann",
+	FirstName:     "Max",
+	LastName:      "Mustermann",
+	WikiPageTitle: "Max Mustermann",
+}
+
+// TestEndToEnd streams events from a fake stream through all stages to the fake Twitter API,
+// wired up like in main
+func TestEndToEnd(t *testing.T) {
+	var (
+		first  = streamtest.Edit("Max Mustermann", 1000, 1200)
+		second = streamtest.Edit("Max Mustermann", 1200, 1500)
+		third  = streamtest.Edit("Max Mustermann", 1500, 1000)
+	)
+
+	stream := streamtest.NewServer(streamtest.Session{
+		Events: []wikipedia.Event{
+			first,
+			streamtest.Edit("Irgendein Artikel", 100, 5000),
+			second,
+			streamtest.Edit("Max Mustermann", 1500, 1510),
+			third,
+		},
+		Hang: true,
+	})
+	defer stream.Close()
+
+	twitter := twittertest.NewServer("politischeswiki")
+	defer twitter.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	politicians := wikidata.NewPoliticianStore(mustermann)
+	matcher := &pipeline.StoreMatcher{Politicians: &politicians}
+
+	source := &pipeline.StreamSource{
+		Streamer: wikipedia.Streamer{URL: stream.URL},
+		Accept:   matcher.Accept,
+	}
+
+	var (
+		mu        sync.Mutex
+		decisions []pipeline.Decision
+	)
+
+	p := &pipeline.Pipeline{
+		Matcher: matcher,
+		Filters: []pipeline.Filter{
+			&pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit}},
+			pipeline.DiffFilter{},
+			&pipeline.SizeFilter{MinSize: 100},
+		},
+		Renderer: fakeRenderer{},
+		Composer: pipeline.TextComposer{},
+		Publisher: &pipeline.RateLimiter{
+			Publisher: &pipeline.TwitterPublisher{
+				Client:     twitter.Client(),
+				ScreenName: twitter.User.ScreenName,
+			},
+			PerArticle: pipeline.RateLimit{PerHour: 1, Burst: 2},
+			Overflow:   pipeline.LimitQueue,
+		},
+		Controls: &pipeline.Controls{},
+		OnDecision: func(d pipeline.Decision) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			decisions = append(decisions, d)
+			// The other article doesn't match, so there's no decision for it
+			if len(decisions) == 4 {
+				cancel()
+			}
+		},
+	}
+
+	p.Run(ctx, source)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var outcomes []string
+	for _, d := range decisions {
+		outcomes = append(outcomes, d.Outcome+"/"+d.Reason)
+	}
+	if want := "posted/ posted/ skipped/small_edit deferred/"; strings.Join(outcomes, " ") != want {
+		t.Fatalf("got outcomes %q, want %q", strings.Join(outcomes, " "), want)
+	}
+
+	tweets := twitter.Tweets()
+	if len(tweets) != 2 {
+		t.Fatalf("got %d tweets, want 2", len(tweets))
+	}
+
+	firstDiff, _ := first.DiffURL()
+	secondDiff, _ := second.DiffURL()
+
+	if want := "Änderung beim Wiki-Eintrag zu Max #Mustermann\n" + firstDiff; tweets[0].Text != want {
+		t.Errorf("got first tweet %q, want %q", tweets[0].Text, want)
+	}
+	if want := "Noch eine Änderung bei Max #Mustermann\n" + secondDiff; tweets[1].Text != want {
+		t.Errorf("got second tweet %q, want %q", tweets[1].Text, want)
+	}
+	if tweets[1].InReplyToStatusID != tweets[0].ID {
+		t.Errorf("second tweet replied to %d, want %d", tweets[1].InReplyToStatusID, tweets[0].ID)
+	}
+
+	for i, tw := range tweets {
+		if len(tw.MediaIDs) != 1 {
+			t.Errorf("tweet %d has media %v, want the screenshot", i+1, tw.MediaIDs)
+			continue
+		}
+		m, _ := twitter.Media(tw.MediaIDs[0])
+		if !strings.HasPrefix(string(m.Data), "\x89PNG ") {
+			t.Errorf("tweet %d has media %q, want the screenshot", i+1, m.Data)
+		}
+	}
+
+	if got := decisions[0].Posts; len(got) != 1 || got[0].ID != tweets[0].ID {
+		t.Errorf("first decision has posts %+v, want the first tweet", got)
+	}
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/attribution"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// TypeFilter skips all events whose type is not in Types. Talk pages are not affected, the TalkPageFilter handles them
+type TypeFilter struct {
+	Types []string
+}
+
+func (f *TypeFilter) Filter(item *Item) error {
+	if item.Event.IsTalkPage() {
+		return nil
+	}
+
+	for _, t := range f.Types {
+		if t == item.Event.Type {
+			return nil
+		}
+	}
+
+	return skipf(ReasonEventType, "not posting %s event (%s/%s) for %q", item.Event.Type, item.Event.LogType, item.Event.LogAction, item.Page)
+}
+
+// DiffFilter sets the diff URL of edits and skips edits for which it can't be generated
+type DiffFilter struct{}
+
+func (DiffFilter) Filter(item *Item) error {
+	if item.Event.Type != wikipedia.TypeEdit {
+		return nil
+	}
+
+	diffURL, ok := item.Event.DiffURL()
+	if !ok {
+		return skipf(ReasonNoDiffURL, "couldn't generate diff URL for edit of %q", item.Page)
+	}
+	item.DiffURL = diffURL
+
+	return nil
+}
+
+// AttributionFilter marks edits from configured networks and accounts and possible self-edits. It never skips anything
+type AttributionFilter struct {
+	Analyzer *attribution.Analyzer
+
+	// ConflictThreshold is the score from which an edit is marked as possible self-edit
+	ConflictThreshold float64
+}
+
+func (f *AttributionFilter) Filter(item *Item) error {
+	if attr, ok := f.Analyzer.Analyze(item.Event.User); ok {
+		item.Attribution = &attr
+		item.Log.Info("edit from configured network or account", "source", attr.Source)
+	}
+
+	if item.Subject.Politician != nil {
+		c := f.Analyzer.Conflict(item.Event.User, *item.Subject.Politician)
+		if c.Score >= f.ConflictThreshold && c.Score > 0 {
+			item.Conflict = &c
+			item.Log.Info("possible self-edit", "score", c.Score, "reasons", strings.Join(c.Reasons, ", "))
+		}
+	}
+
+	return nil
+}
+
+// SizeFilter skips article edits that changed the length by less than MinSize characters.
+// Edits that were attributed or are possible self-edits are always interesting, no matter how small they are
+type SizeFilter struct {
+	MinSize int
+}
+
+func (f *SizeFilter) Filter(item *Item) error {
+	if !item.NeedsImage() || item.Attribution != nil || item.Conflict != nil {
+		return nil
+	}
+
+	if item.Event.SizeDifference() < f.MinSize {
+		return skipf(ReasonSmallEdit, "skipping small edit %s", item.DiffURL)
+	}
+
+	return nil
+}
+
+// TalkPageFilter decides which changes to talk pages are posted. Discussions are often busy,
+// so they have their own limits that are independent from the ones of articles.
+// A post only counts towards the limits if it was actually posted, see Finish
+type TalkPageFilter struct {
+	// MinSize is the minimum size change of edits that don't add a new section
+	MinSize int
+
+	// MinInterval is the minimum time between two posts about the same talk page
+	MinInterval time.Duration
+
+	// MaxPerHour is the maximum number of posts about all talk pages within an hour
+	MaxPerHour int
+
+	mu     sync.Mutex
+	last   map[string]time.Time
+	recent []time.Time
+
+	// reserved contains the slots of items that passed the filter, but weren't finished yet
+	reserved map[*Item]talkSlot
+}
+
+// talkSlot is a post that was allowed by the TalkPageFilter. prev is the time of the post before it about the same page
+type talkSlot struct {
+	at, prev time.Time
+}
+
+func (f *TalkPageFilter) Filter(item *Item) error {
+	if !item.Event.IsTalkPage() {
+		return nil
+	}
+
+	_, newSection := item.Event.NewSection()
+	if item.Event.Type != wikipedia.TypeNew && !newSection && item.Event.SizeDifference() < f.MinSize {
+		return skipf(ReasonSmallEdit, "not posting small change to %q", item.Page)
+	}
+
+	if !f.allow(item) {
+		return skipf(ReasonTalkLimit, "talk page limit reached, not posting change to %q", item.Page)
+	}
+
+	return nil
+}
+
+// Finish gives back the slot of the item if it wasn't posted
+func (f *TalkPageFilter) Finish(item *Item, posted bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	slot, ok := f.reserved[item]
+	if !ok {
+		return
+	}
+	delete(f.reserved, item)
+
+	if posted {
+		return
+	}
+
+	for i, t := range f.recent {
+		if t.Equal(slot.at) {
+			f.recent = append(f.recent[:i], f.recent[i+1:]...)
+			break
+		}
+	}
+
+	// Only go back if there was no other post about the page in the meantime
+	if f.last[item.Page].Equal(slot.at) {
+		if slot.prev.IsZero() {
+			delete(f.last, item.Page)
+		} else {
+			f.last[item.Page] = slot.prev
+		}
+	}
+}
+
+// allow returns whether we may post about the talk page now. If it returns true, the post is counted
+func (f *TalkPageFilter) allow(item *Item) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var now = time.Now()
+
+	if f.last == nil {
+		f.last = make(map[string]time.Time)
+		f.reserved = make(map[*Item]talkSlot)
+	}
+
+	var title = item.Page
+	if now.Sub(f.last[title]) < f.MinInterval {
+		return false
+	}
+
+	// Only keep the posts of the last hour
+	var i int
+	for i < len(f.recent) && now.Sub(f.recent[i]) >= time.Hour {
+		i++
+	}
+	f.recent = f.recent[i:]
+
+	if len(f.recent) >= f.MaxPerHour {
+		return false
+	}
+
+	f.recent = append(f.recent, now)
+	f.reserved[item] = talkSlot{at: now, prev: f.last[title]}
+	f.last[title] = now
+
+	return true
+}
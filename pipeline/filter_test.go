@@ -0,0 +1,172 @@
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/attribution"
+	"github.com/xarantolus/poliwiki/config"
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+
+	"gopkg.in/yaml.v3"
+)
+
+func talkEdit(title string, oldLength, newLength int, comment string) wikipedia.Event {
+	e := streamtest.Edit("Diskussion:"+title, oldLength, newLength)
+	e.Comment = comment
+	return e
+}
+
+func TestFilters(t *testing.T) {
+	var noOldRevision = streamtest.Edit("Max Mustermann", 100, 1000)
+	noOldRevision.Revision.Old = 0
+
+	var tests = []struct {
+		name   string
+		filter pipeline.Filter
+		event  wikipedia.Event
+		setup  func(item *pipeline.Item)
+
+		wantReason string
+	}{
+		{"type edit", &pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit}}, streamtest.Edit("Max Mustermann", 100, 200), nil, ""},
+		{"type new", &pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit}}, streamtest.NewPage("Max Mustermann", 200), nil, pipeline.ReasonEventType},
+		{"type log", &pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit, wikipedia.TypeLog}}, streamtest.Log("Max Mustermann", wikipedia.LogTypeDelete, "delete"), nil, ""},
+		{"type talk page", &pipeline.TypeFilter{Types: []string{wikipedia.TypeLog}}, talkEdit("Max Mustermann", 100, 200, ""), nil, ""},
+
+		{"diff", pipeline.DiffFilter{}, streamtest.Edit("Max Mustermann", 100, 200), nil, ""},
+		{"diff without old revision", pipeline.DiffFilter{}, noOldRevision, nil, pipeline.ReasonNoDiffURL},
+		{"diff of new page", pipeline.DiffFilter{}, streamtest.NewPage("Max Mustermann", 200), nil, ""},
+
+		{"size", &pipeline.SizeFilter{MinSize: 100}, streamtest.Edit("Max Mustermann", 100, 200), nil, ""},
+		{"size removed", &pipeline.SizeFilter{MinSize: 100}, streamtest.Edit("Max Mustermann", 300, 100), nil, ""},
+		{"size small", &pipeline.SizeFilter{MinSize: 100}, streamtest.Edit("Max Mustermann", 100, 150), nil, pipeline.ReasonSmallEdit},
+		{"size small attributed", &pipeline.SizeFilter{MinSize: 100}, streamtest.Edit("Max Mustermann", 100, 150), func(item *pipeline.Item) {
+			item.Attribution = &attribution.Attribution{Source: "Bundestag"}
+		}, ""},
+		{"size small conflict", &pipeline.SizeFilter{MinSize: 100}, streamtest.Edit("Max Mustermann", 100, 150), func(item *pipeline.Item) {
+			item.Conflict = &attribution.Conflict{Score: 0.8}
+		}, ""},
+		{"size talk page", &pipeline.SizeFilter{MinSize: 100}, talkEdit("Max Mustermann", 100, 110, ""), nil, ""},
+		{"size new page", &pipeline.SizeFilter{MinSize: 100}, streamtest.NewPage("Max Mustermann", 10), nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := newItem(tt.event, mustermann)
+			if tt.setup != nil {
+				tt.setup(item)
+			}
+
+			err := tt.filter.Filter(item)
+			if reason := skipReason(err); reason != tt.wantReason || (err != nil && reason == "") {
+				t.Errorf("got error %v with reason %q, want %q", err, reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDiffFilterURL(t *testing.T) {
+	e := streamtest.Edit("Max Mustermann", 100, 200)
+	item := newItem(e, mustermann)
+
+	err := pipeline.DiffFilter{}.Filter(item)
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+
+	if want, _ := e.DiffURL(); item.DiffURL != want {
+		t.Errorf("got diff URL %q, want %q", item.DiffURL, want)
+	}
+}
+
+func TestTalkPageFilter(t *testing.T) {
+	f := &pipeline.TalkPageFilter{
+		MinSize:     500,
+		MinInterval: time.Hour,
+		MaxPerHour:  2,
+	}
+
+	// The filter keeps track of what was posted, so the order matters
+	var steps = []struct {
+		name  string
+		event wikipedia.Event
+
+		wantReason string
+	}{
+		{"article", streamtest.Edit("Max Mustermann", 100, 110), ""},
+		{"small edit", talkEdit("Max Mustermann", 100, 110, "Antwort"), pipeline.ReasonSmallEdit},
+		{"new section", talkEdit("Max Mustermann", 100, 110, "/* Parteiwechsel */ neuer Abschnitt"), ""},
+		{"same page again", talkEdit("Max Mustermann", 100, 1000, "Antwort"), pipeline.ReasonTalkLimit},
+		{"new talk page", streamtest.NewPage("Diskussion:Erika Musterfrau", 100), ""},
+		{"limit per hour", talkEdit("Sozialdemokratische Partei Deutschlands", 100, 1000, ""), pipeline.ReasonTalkLimit},
+	}
+
+	for _, s := range steps {
+		err := f.Filter(newItem(s.event, mustermann))
+		if reason := skipReason(err); reason != s.wantReason {
+			t.Errorf("%s: got error %v with reason %q, want %q", s.name, err, reason, s.wantReason)
+		}
+	}
+}
+
+func TestAttributionFilter(t *testing.T) {
+	var cfg config.Config
+	err := yaml.Unmarshal([]byte(`
+attribution:
+  networks:
+    - name: Bundestag
+      text: Änderung aus dem Netz des Bundestags
+      ranges: ["192.0.2.0/24"]
+  accounts:
+    - user: Pressestelle
+      text: Änderung durch die Pressestelle
+`), &cfg)
+	if err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+
+	analyzer, err := attribution.New(cfg)
+	if err != nil {
+		t.Fatalf("creating analyzer: %v", err)
+	}
+
+	var tests = []struct {
+		user      string
+		threshold float64
+
+		wantAttribution string
+		wantConflict    bool
+	}{
+		{"Beispielnutzer", 0.5, "", false},
+		{"192.0.2.7", 0.5, "Änderung aus dem Netz des Bundestags", false},
+		{"198.51.100.7", 0.5, "", false},
+		{"Pressestelle", 0.5, "Änderung durch die Pressestelle", false},
+		{"MaxMustermann", 0.5, "", true},
+		{"MaxMustermann", 0.9, "", false},
+	}
+
+	for _, tt := range tests {
+		e := streamtest.Edit("Max Mustermann", 100, 110)
+		e.User = tt.user
+		item := newItem(e, mustermann)
+
+		f := &pipeline.AttributionFilter{Analyzer: analyzer, ConflictThreshold: tt.threshold}
+		if err := f.Filter(item); err != nil {
+			t.Errorf("%s: filter returned %v, but it never skips", tt.user, err)
+		}
+
+		var text string
+		if item.Attribution != nil {
+			text = item.Attribution.Text
+		}
+		if text != tt.wantAttribution {
+			t.Errorf("%s: got attribution %q, want %q", tt.user, text, tt.wantAttribution)
+		}
+		if conflict := item.Conflict != nil; conflict != tt.wantConflict {
+			t.Errorf("%s (threshold %v): got conflict %v, want %v", tt.user, tt.threshold, conflict, tt.wantConflict)
+		}
+	}
+}
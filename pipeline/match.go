@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/xarantolus/poliwiki/util"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// StoreMatcher matches events to the politicians and organizations in the stores
+type StoreMatcher struct {
+	Politicians   *wikidata.PoliticianStore
+	Organizations *wikidata.OrganizationStore
+
+	// Levels restricts politicians to those that hold a position on one of these levels. Empty means all politicians
+	Levels []wikidata.Level
+
+	// TalkPages enables matching edits and creations of the talk pages of tracked articles
+	TalkPages bool
+
+	// Resolve looks up the politician of an article that isn't in the store, e.g. wikidata.PoliticianByTitle.
+	// If set, it's called for newly created articles, as they can't be in the store that was loaded at startup
+	Resolve func(title string) (p wikidata.Politician, ok bool, err error)
+
+	mu sync.Mutex
+	// resolved contains the politicians that were found by Resolve
+	resolved map[string]wikidata.Politician
+}
+
+// Accept returns whether the event is about a tracked article. It can be used for filtering the stream.
+// If Resolve is set, newly created articles are always accepted, as Match has to look them up first
+func (m *StoreMatcher) Accept(e *wikipedia.Event) bool {
+	if e.IsTalkPage() && (!m.TalkPages || (e.Type != wikipedia.TypeEdit && e.Type != wikipedia.TypeNew)) {
+		return false
+	}
+
+	if poli, ok := m.politician(e.SubjectTitle()); ok {
+		return len(m.Levels) == 0 || poli.HasLevel(m.Levels...)
+	}
+	if m.Organizations != nil && m.Organizations.Contains(e.SubjectTitle()) {
+		return true
+	}
+	return m.resolvable(e)
+}
+
+func (m *StoreMatcher) Match(e *wikipedia.Event) (s Subject, ok bool) {
+	if !m.Accept(e) {
+		return
+	}
+
+	s.Title = e.SubjectTitle()
+
+	poli, ok := m.politician(s.Title)
+	if !ok && m.resolvable(e) {
+		poli, ok = m.resolve(e)
+	}
+	if ok {
+		if len(m.Levels) > 0 && !poli.HasLevel(m.Levels...) {
+			return Subject{}, false
+		}
+
+		s.Politician = &poli
+		// If there's no name, the pipeline skips the event
+		s.Name, _ = PoliticianName(poli)
+		return s, true
+	}
+
+	if m.Organizations != nil {
+		if org, ok := m.Organizations.Get(s.Title); ok {
+			s.Organization = &org
+			s.Name = OrganizationName(org)
+			return s, true
+		}
+	}
+
+	return Subject{}, false
+}
+
+// politician returns the politician from the store or one that was resolved earlier
+func (m *StoreMatcher) politician(title string) (poli wikidata.Politician, ok bool) {
+	if poli, ok = m.Politicians.Get(title); ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	poli, ok = m.resolved[title]
+	return
+}
+
+// resolvable returns whether the event is the creation of an article that should be looked up using Resolve
+func (m *StoreMatcher) resolvable(e *wikipedia.Event) bool {
+	return m.Resolve != nil && e.Type == wikipedia.TypeNew && e.Namespace == 0
+}
+
+// resolve looks up the politician of a new article and remembers it for later events about the article
+func (m *StoreMatcher) resolve(e *wikipedia.Event) (poli wikidata.Politician, ok bool) {
+	poli, ok, err := m.Resolve(e.SubjectTitle())
+	if err != nil {
+		logger.Warn("looking up new article on WikiData failed", append(e.LogFields(), "err", err)...)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.resolved == nil {
+		m.resolved = make(map[string]wikidata.Politician)
+	}
+	m.resolved[e.SubjectTitle()] = poli
+
+	return
+}
+
+// PoliticianName returns the name of the politician as it should be shown in a post,
+// e.g. "MdB Max #Mustermann (#SPD, Wahlkreis München-Nord)"
+func PoliticianName(poli wikidata.Politician) (nameText string, ok bool) {
+	switch {
+	case poli.FirstName == "" && poli.LastName != "":
+		nameText = util.Hashtag(poli.LastName)
+	case poli.FirstName != "" && poli.LastName != "":
+		nameText = poli.FirstName + " " + util.Hashtag(poli.LastName)
+	case poli.Name != "":
+		nameText = poli.Name
+	default:
+		return "", false
+	}
+
+	if pos, ok := poli.MainPosition(); ok && pos.ShortName != "" {
+		nameText = pos.ShortName + " " + nameText
+	}
+
+	var details []string
+	if party := poli.PartyHashtag(); party != "" {
+		details = append(details, util.Hashtag(party))
+	}
+	if constituency := poli.Constituency(); constituency != "" {
+		details = append(details, constituencyName(constituency))
+	}
+	if len(details) > 0 {
+		nameText += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return nameText, true
+}
+
+// constituencyName shortens the name of a constituency, e.g. "Bundestagswahlkreis München-Nord" becomes "Wahlkreis München-Nord"
+func constituencyName(constituency string) string {
+	for _, prefix := range []string{"Bundestagswahlkreis ", "Landtagswahlkreis "} {
+		if strings.HasPrefix(constituency, prefix) {
+			return "Wahlkreis " + strings.TrimPrefix(constituency, prefix)
+		}
+	}
+	return constituency
+}
+
+// OrganizationName returns the name of the organization as it should be shown in a post.
+// Parties are shown by their hashtag if they have one, e.g. "#SPD"
+func OrganizationName(org wikidata.Organization) string {
+	switch {
+	case org.Hashtag != "":
+		return util.Hashtag(org.Hashtag)
+	case org.Kind == wikidata.KindParty && org.ShortName != "":
+		return util.Hashtag(org.ShortName)
+	default:
+		return org.Name
+	}
+}
@@ -0,0 +1,177 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+var (
+	musterfrau = wikidata.Politician{
+		ID:            "Q2",
+		Name:          "Erika Musterfrau",
+		FirstName:     "Erika",
+		LastName:      "Musterfrau",
+		WikiPageTitle: "Erika Musterfrau",
+		Parties:       []wikidata.PartyMembership{{ID: "Q49768", Name: "Sozialdemokratische Partei Deutschlands", ShortName: "SPD"}},
+		Positions: []wikidata.Position{{
+			Name:         "Mitglied des Landtags von Bayern",
+			ShortName:    "MdL",
+			Level:        wikidata.LevelState,
+			Constituency: "Landtagswahlkreis München-Nord",
+		}},
+	}
+
+	spd = wikidata.Organization{
+		Kind:          wikidata.KindParty,
+		Name:          "Sozialdemokratische Partei Deutschlands",
+		ShortName:     "SPD",
+		WikiPageTitle: "Sozialdemokratische Partei Deutschlands",
+	}
+)
+
+func categorize(page string, removed bool) wikipedia.Event {
+	e := wikipedia.Event{
+		Type:      wikipedia.TypeCategorize,
+		Title:     "Kategorie:Mitglied des Bayerischen Landtags",
+		Namespace: 14,
+		Wiki:      "dewiki",
+		Comment:   "[[:" + page + "]] zur Kategorie hinzugefügt",
+	}
+	if removed {
+		e.Comment = "[[:" + page + "]] aus der Kategorie entfernt"
+	}
+	return e
+}
+
+func TestStoreMatcher(t *testing.T) {
+	politicians := wikidata.NewPoliticianStore(mustermann, musterfrau)
+	organizations := wikidata.NewOrganizationStore(spd)
+
+	var tests = []struct {
+		name      string
+		levels    []wikidata.Level
+		talkPages bool
+		event     wikipedia.Event
+
+		wantOK    bool
+		wantTitle string
+		wantName  string
+	}{
+		{
+			name:      "politician",
+			event:     streamtest.Edit("Max Mustermann", 100, 200),
+			wantOK:    true,
+			wantTitle: "Max Mustermann",
+			wantName:  "Max #Mustermann",
+		},
+		{
+			name:      "politician with position and party",
+			event:     streamtest.Edit("Erika Musterfrau", 100, 200),
+			wantOK:    true,
+			wantTitle: "Erika Musterfrau",
+			wantName:  "MdL Erika #Musterfrau (#SPD, Wahlkreis München-Nord)",
+		},
+		{
+			name:      "party",
+			event:     streamtest.Edit("Sozialdemokratische Partei Deutschlands", 100, 200),
+			wantOK:    true,
+			wantTitle: "Sozialdemokratische Partei Deutschlands",
+			wantName:  "#SPD",
+		},
+		{
+			name:  "unknown article",
+			event: streamtest.Edit("Irgendein Artikel", 100, 200),
+		},
+		{
+			name:   "level",
+			levels: []wikidata.Level{wikidata.LevelFederal},
+			event:  streamtest.Edit("Erika Musterfrau", 100, 200),
+		},
+		{
+			name:      "matching level",
+			levels:    []wikidata.Level{wikidata.LevelState},
+			event:     streamtest.Edit("Erika Musterfrau", 100, 200),
+			wantOK:    true,
+			wantTitle: "Erika Musterfrau",
+			wantName:  "MdL Erika #Musterfrau (#SPD, Wahlkreis München-Nord)",
+		},
+		{
+			name:  "talk page disabled",
+			event: streamtest.Edit("Diskussion:Max Mustermann", 100, 200),
+		},
+		{
+			name:      "talk page",
+			talkPages: true,
+			event:     streamtest.Edit("Diskussion:Max Mustermann", 100, 200),
+			wantOK:    true,
+			wantTitle: "Max Mustermann",
+			wantName:  "Max #Mustermann",
+		},
+		{
+			name:      "log event of talk page",
+			talkPages: true,
+			event:     streamtest.Log("Diskussion:Max Mustermann", wikipedia.LogTypeProtect, "protect"),
+		},
+		{
+			name:      "categorize",
+			event:     categorize("Max Mustermann", false),
+			wantOK:    true,
+			wantTitle: "Max Mustermann",
+			wantName:  "Max #Mustermann",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &pipeline.StoreMatcher{
+				Politicians:   &politicians,
+				Organizations: &organizations,
+				Levels:        tt.levels,
+				TalkPages:     tt.talkPages,
+			}
+
+			s, ok := m.Match(&tt.event)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if accepted := m.Accept(&tt.event); accepted != ok {
+				t.Errorf("Accept returned %v, but Match %v", accepted, ok)
+			}
+			if !ok {
+				return
+			}
+
+			if s.Title != tt.wantTitle || s.Name != tt.wantName {
+				t.Errorf("got subject %q named %q, want %q named %q", s.Title, s.Name, tt.wantTitle, tt.wantName)
+			}
+			if (s.Politician == nil) == (s.Organization == nil) {
+				t.Errorf("exactly one of politician and organization must be set, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestPoliticianName(t *testing.T) {
+	var tests = []struct {
+		poli   wikidata.Politician
+		want   string
+		wantOK bool
+	}{
+		{mustermann, "Max #Mustermann", true},
+		{musterfrau, "MdL Erika #Musterfrau (#SPD, Wahlkreis München-Nord)", true},
+		{wikidata.Politician{LastName: "Müller-Lüdenscheidt"}, "#MüllerLüdenscheidt", true},
+		{wikidata.Politician{Name: "Prinz Max"}, "Prinz Max", true},
+		{wikidata.Politician{}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := pipeline.PoliticianName(tt.poli)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("PoliticianName(%+v) = %q, %v, want %q, %v", tt.poli, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/xarantolus/poliwiki/metrics"
+)
+
+var (
+	matches  = metrics.NewCounterVec("poliwiki_matches_total", "Events about tracked articles", "kind")
+	skips    = metrics.NewCounterVec("poliwiki_skips_total", "Events that were not posted", "reason")
+	failures = metrics.NewCounterVec("poliwiki_failures_total", "Events that could not be posted because of an error", "stage")
+	posts    = metrics.NewCounter("poliwiki_posts_total", "Posts that were published")
+	held     = metrics.NewCounter("poliwiki_held_total", "Events whose post waits for moderation")
+	deferred = metrics.NewCounter("poliwiki_deferred_total", "Events whose post will be published later because of a rate limit")
+
+	rateLimited = metrics.NewCounterVec("poliwiki_rate_limited_total", "Posts that exceeded a rate limit, action is \"dropped\", \"queued\" or \"digest\"", "action")
+
+	screenshotDuration = metrics.NewHistogram("poliwiki_screenshot_duration_seconds", "Time it took to take a screenshot", metrics.DurationBuckets)
+	screenshotFailures = metrics.NewCounter("poliwiki_screenshot_failures_total", "Screenshots that failed, not counting uninteresting changes")
+
+	twitterErrors = metrics.NewCounterVec("poliwiki_twitter_errors_total", "Errors returned by twitter, code is the twitter error code or \"none\" for other errors", "endpoint", "code")
+
+	poolDropped        = metrics.NewCounter("poliwiki_pool_dropped_total", "Events dropped because the queue of their worker was full")
+	poolBlockedSeconds = metrics.NewCounter("poliwiki_pool_blocked_seconds_total", "Time reading from the stream was paused because a queue was full")
+)
+
+// countDecision updates the metrics for the decision
+func countDecision(d Decision) {
+	switch {
+	case d.Item.Subject.Politician != nil:
+		matches.With("politician").Inc()
+	case d.Item.Subject.Organization != nil:
+		matches.With("organization").Inc()
+	}
+
+	// Observers can post even if the event itself is skipped
+	posts.Add(float64(len(d.Posts)))
+
+	switch d.Outcome {
+	case OutcomeSkipped:
+		skips.With(d.Reason).Inc()
+	case OutcomeFailed:
+		failures.With(d.Reason).Inc()
+	case OutcomeHeld:
+		held.Inc()
+	case OutcomeDeferred:
+		deferred.Inc()
+	}
+}
+
+// countTwitterError counts an error returned by the twitter API
+func countTwitterError(endpoint string, err error) {
+	var code = "none"
+
+	var apiErr twitter.APIError
+	if errors.As(err, &apiErr) && !apiErr.Empty() {
+		code = strconv.Itoa(apiErr.Errors[0].Code)
+	}
+
+	twitterErrors.With(endpoint, code).Inc()
+}
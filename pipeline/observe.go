@@ -0,0 +1,29 @@
+package pipeline
+
+import "github.com/xarantolus/poliwiki/editwar"
+
+// EditWarObserver posts alerts about edit wars and new protections. New protections are consumed,
+// as the alert already contains everything about them. Other protection events are posted as usual
+type EditWarObserver struct {
+	Detector *editwar.Detector
+}
+
+func (o *EditWarObserver) Observe(item *Item) (posts []Post, consumed bool) {
+	if item.Event.IsTalkPage() {
+		return
+	}
+
+	alert, ok := o.Detector.Add(item.Event)
+	if ok {
+		item.Log.Info("edit war alert", "edits", len(alert.Edits), "reverts", alert.Reverts)
+
+		for _, text := range alert.Tweets(item.Subject.Name, o.Detector.Window) {
+			posts = append(posts, Post{
+				ThreadKey: item.Page,
+				Text:      text,
+			})
+		}
+	}
+
+	return posts, ok && alert.Protection != nil
+}
@@ -0,0 +1,405 @@
+// Package pipeline contains the stages every event from the stream goes through before it is posted:
+// source → match → filter → render → compose → publish.
+// Every stage is an interface, so stages can be tested on their own and swapped out
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xarantolus/poliwiki/attribution"
+	"github.com/xarantolus/poliwiki/logging"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+var logger = logging.With("component", "pipeline")
+
+// Source provides the events that should be processed. The channel is closed when ctx is cancelled
+type Source interface {
+	Events(ctx context.Context) <-chan wikipedia.Event
+}
+
+// Matcher finds out what an event is about. ok is false if we don't track the article
+type Matcher interface {
+	Match(e *wikipedia.Event) (s Subject, ok bool)
+}
+
+// Observer sees every matched event before it is filtered, e.g. for detecting edit wars.
+// It can return posts that are published right away. If consumed is true, the event is not processed any further
+type Observer interface {
+	Observe(item *Item) (posts []Post, consumed bool)
+}
+
+// Filter decides whether an item should be posted. It returns a *Skip error if it should not.
+// Filters may also add information to the item
+type Filter interface {
+	Filter(item *Item) error
+}
+
+// Finisher is implemented by filters that reserve something for an item, e.g. a slot of a rate limit.
+// Finish is called after an item passed the filter and went through the rest of the pipeline. posted is false
+// if the item wasn't posted after all (e.g. because rendering or publishing failed), so the reservation can be given back.
+// Held and deferred posts count as posted
+type Finisher interface {
+	Finish(item *Item, posted bool)
+}
+
+// Renderer creates the image for an item, e.g. a screenshot of the diff
+type Renderer interface {
+	Render(ctx context.Context, item *Item) (png []byte, err error)
+}
+
+// Composer creates the post for an item
+type Composer interface {
+	Compose(item *Item) (Post, error)
+}
+
+// Moderator can hold back posts until a human approved them. If held is true, the post is not published by the
+// pipeline, the moderator is responsible for publishing it later (or never)
+type Moderator interface {
+	Moderate(ctx context.Context, item *Item, post Post) (held bool, err error)
+}
+
+// Publisher posts a post
+type Publisher interface {
+	Publish(ctx context.Context, post Post) (Published, error)
+}
+
+// Subject is the article an event is about
+type Subject struct {
+	// Title of the article. For talk pages, it's the title of the article the talk page belongs to
+	Title string
+
+	// Exactly one of Politician and Organization is set
+	Politician   *wikidata.Politician
+	Organization *wikidata.Organization
+
+	// Name is the name that should be used in posts, e.g. "Max #Mustermann (#SPD)"
+	Name string
+}
+
+// Item is an event that goes through the pipeline, every stage adds some information
+type Item struct {
+	Event wikipedia.Event
+
+	// Page is the title of the page that was changed. It's only different from Subject.Title for talk pages
+	Page string
+
+	Subject Subject
+
+	// DiffURL is set for edits
+	DiffURL string
+
+	// Attribution is set if the edit came from a configured network or account
+	Attribution *attribution.Attribution
+
+	// Conflict is set if the edit might have been made by the politician themselves
+	Conflict *attribution.Conflict
+
+	// Image is the rendered screenshot, if any
+	Image []byte
+
+	// Log writes lines that contain the revision ID and title of the event, stages should use it for everything about the item
+	Log *logging.Logger
+}
+
+// NeedsImage returns whether a screenshot should be rendered for the item.
+// That's only the case for edits of articles, all other events are posted without image
+func (i *Item) NeedsImage() bool {
+	return i.Event.Type == wikipedia.TypeEdit && !i.Event.IsTalkPage()
+}
+
+// Post is something that should be posted
+type Post struct {
+	// ThreadKey identifies the thread this post belongs to, usually the page title.
+	// If there was a post with the same key recently, this post is added to that thread
+	ThreadKey string
+
+	Text string
+
+	// FollowupText is used instead of Text if the post is added to an existing thread. Defaults to Text
+	FollowupText string
+
+	// Image is a PNG image, could be nil
+	Image []byte
+
+	// Observer is set for posts of observers. They are about several events, e.g. edit war alerts, so
+	// they only count towards the global rate limit and not towards the one of the article
+	Observer bool
+
+	// Log is the Log of the item the post is about, so posts that are published later can still be traced
+	// back to their event. Could be nil, e.g. for digests
+	Log *logging.Logger
+}
+
+// log returns Log, or the package logger if it isn't set
+func (p Post) log() *logging.Logger {
+	if p.Log != nil {
+		return p.Log
+	}
+	return logger
+}
+
+// Published describes a post that was published
+type Published struct {
+	ID  int64
+	URL string
+
+	// InReplyTo is the ID of the post this one replied to, or zero
+	InReplyTo int64
+}
+
+// Stages that can fail, used as reason of failed decisions
+const (
+	StageFilter   = "filter"
+	StageRender   = "render"
+	StageCompose  = "compose"
+	StageModerate = "moderate"
+	StagePublish  = "publish"
+)
+
+// Outcomes of processing an event
+const (
+	OutcomePosted  = "posted"
+	OutcomeSkipped = "skipped"
+	OutcomeFailed  = "failed"
+
+	// OutcomeHeld means the post waits for moderation
+	OutcomeHeld = "held"
+
+	// OutcomeDeferred means the publisher will publish the post later, see ErrDeferred
+	OutcomeDeferred = "deferred"
+)
+
+// Decision describes what happened to an event
+type Decision struct {
+	Item Item
+
+	// Outcome is one of the Outcome constants
+	Outcome string
+
+	// Reason is the skip reason or the stage that failed, one of the Stage constants
+	Reason string
+
+	// Err contains details about why the event was skipped or failed
+	Err error
+
+	// Posts are all posts that were published because of this event, including the ones of observers
+	Posts []Published
+}
+
+// Pipeline connects all stages
+type Pipeline struct {
+	Matcher   Matcher
+	Observers []Observer
+	Filters   []Filter
+	Renderer  Renderer
+	Composer  Composer
+	Publisher Publisher
+
+	// Moderator is optional, without one all posts are published right away
+	Moderator Moderator
+
+	// Controls can pause the pipeline and mute articles. They are checked before the observers, so while
+	// paused, observers don't see any events either, and none about muted articles. Optional
+	Controls *Controls
+
+	// OnDecision is called after every event that matched, it can be nil
+	OnDecision func(d Decision)
+}
+
+// Run processes all events of the source until the channel is closed
+func (p *Pipeline) Run(ctx context.Context, source Source) {
+	for e := range source.Events(ctx) {
+		p.handle(ctx, e)
+	}
+}
+
+// handle processes the event, logs the decision and calls OnDecision
+func (p *Pipeline) handle(ctx context.Context, e wikipedia.Event) {
+	d, matched := p.Process(ctx, e)
+	if !matched {
+		return
+	}
+
+	logDecision(d)
+	countDecision(d)
+
+	if p.OnDecision != nil {
+		p.OnDecision(d)
+	}
+}
+
+// Process runs the event through all stages. matched is false if the event isn't about an article we track
+func (p *Pipeline) Process(ctx context.Context, e wikipedia.Event) (d Decision, matched bool) {
+	subject, ok := p.Matcher.Match(&e)
+	if !ok {
+		return
+	}
+
+	d.Item = Item{
+		Event:   e,
+		Page:    e.PageTitle(),
+		Subject: subject,
+		Log:     logger.With(e.LogFields()...),
+	}
+	var item = &d.Item
+
+	item.Log.Debug("matched event", "type", e.Type, "subject", subject.Title, "size_diff", e.SizeDifference())
+
+	if subject.Name == "" {
+		return d.skip(&Skip{Reason: ReasonNoName, Detail: fmt.Sprintf("couldn't find a name for %q", subject.Title)}), true
+	}
+
+	if p.Controls != nil {
+		err := p.Controls.check(item)
+		if err != nil {
+			return d.skip(err), true
+		}
+	}
+
+	for _, o := range p.Observers {
+		posts, consumed := o.Observe(item)
+		for _, post := range posts {
+			post.Log = item.Log
+			post.Observer = true
+
+			held, err := p.moderate(ctx, item, post)
+			if err != nil {
+				item.Log.Error("moderating post of observer failed", "err", err)
+				continue
+			}
+			if held {
+				continue
+			}
+
+			pub, err := p.Publisher.Publish(ctx, post)
+			if errors.Is(err, ErrDeferred) {
+				item.Log.Info("post of observer deferred", "detail", err)
+				continue
+			}
+			if err != nil {
+				item.Log.Error("publishing post of observer failed", "err", err)
+				continue
+			}
+			d.Posts = append(d.Posts, pub)
+		}
+
+		if consumed {
+			return d.skip(&Skip{Reason: ReasonObserved, Detail: "handled by observer"}), true
+		}
+	}
+
+	var passed []Filter
+	defer func() {
+		posted := d.Outcome == OutcomePosted || d.Outcome == OutcomeHeld || d.Outcome == OutcomeDeferred
+		for _, f := range passed {
+			if finisher, ok := f.(Finisher); ok {
+				finisher.Finish(item, posted)
+			}
+		}
+	}()
+
+	for _, f := range p.Filters {
+		err := f.Filter(item)
+		if err != nil {
+			return d.skip(err), true
+		}
+		passed = append(passed, f)
+	}
+
+	if item.NeedsImage() && p.Renderer != nil {
+		png, err := p.Renderer.Render(ctx, item)
+		if err != nil {
+			return d.fail(StageRender, err), true
+		}
+		item.Image = png
+	}
+
+	post, err := p.Composer.Compose(item)
+	if err != nil {
+		return d.fail(StageCompose, err), true
+	}
+	post.Log = item.Log
+
+	held, err := p.moderate(ctx, item, post)
+	if err != nil {
+		return d.fail(StageModerate, err), true
+	}
+	if held {
+		d.Outcome = OutcomeHeld
+		return d, true
+	}
+
+	pub, err := p.Publisher.Publish(ctx, post)
+	if errors.Is(err, ErrDeferred) {
+		d.Outcome = OutcomeDeferred
+		d.Err = err
+		return d, true
+	}
+	if err != nil {
+		return d.fail(StagePublish, err), true
+	}
+
+	d.Posts = append(d.Posts, pub)
+	d.Outcome = OutcomePosted
+
+	return d, true
+}
+
+func (p *Pipeline) moderate(ctx context.Context, item *Item, post Post) (held bool, err error) {
+	if p.Moderator == nil {
+		return false, nil
+	}
+	return p.Moderator.Moderate(ctx, item, post)
+}
+
+// skip sets the decision to skipped. Errors that are not a *Skip are treated as failures
+func (d Decision) skip(err error) Decision {
+	var s *Skip
+	if !errors.As(err, &s) {
+		return d.fail(StageFilter, err)
+	}
+
+	d.Outcome = OutcomeSkipped
+	d.Reason = s.Reason
+	d.Err = err
+
+	return d
+}
+
+// fail sets the decision to failed. Skips are still treated as skips, as e.g. the
+// renderer can find out that a change is not interesting
+func (d Decision) fail(stage string, err error) Decision {
+	var s *Skip
+	if errors.As(err, &s) {
+		return d.skip(s)
+	}
+
+	d.Outcome = OutcomeFailed
+	d.Reason = stage
+	d.Err = err
+
+	return d
+}
+
+func logDecision(d Decision) {
+	l := d.Item.Log
+
+	for _, p := range d.Posts {
+		l.Info("posted", "url", p.URL, "in_reply_to", p.InReplyTo)
+	}
+
+	switch d.Outcome {
+	case OutcomeSkipped:
+		l.Info("skipped", "reason", d.Reason, "detail", d.Err)
+	case OutcomeFailed:
+		l.Error("failed", "stage", d.Reason, "err", d.Err)
+	case OutcomeHeld:
+		l.Info("held for moderation")
+	case OutcomeDeferred:
+		l.Info("deferred", "detail", d.Err)
+	}
+}
@@ -0,0 +1,501 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/config"
+	"github.com/xarantolus/poliwiki/editwar"
+	"github.com/xarantolus/poliwiki/logging"
+	"github.com/xarantolus/poliwiki/mute"
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+// recorder is a publisher that remembers all posts. If err is set, it's returned instead
+type recorder struct {
+	err error
+
+	mu    sync.Mutex
+	posts []pipeline.Post
+}
+
+func (r *recorder) Publish(ctx context.Context, post pipeline.Post) (pub pipeline.Published, err error) {
+	if r.err != nil {
+		return pub, r.err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.posts = append(r.posts, post)
+
+	pub.ID = int64(len(r.posts))
+	pub.URL = fmt.Sprintf("https://twitter.com/politischeswiki/status/%d", pub.ID)
+
+	return
+}
+
+func (r *recorder) texts() (texts []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.posts {
+		texts = append(texts, p.Text)
+	}
+	return
+}
+
+// holdModerator holds back every post
+type holdModerator struct {
+	held []pipeline.Post
+}
+
+func (m *holdModerator) Moderate(ctx context.Context, item *pipeline.Item, post pipeline.Post) (held bool, err error) {
+	m.held = append(m.held, post)
+	return true, nil
+}
+
+// newItem returns the item for an event about the politician, as the pipeline creates it before the filters
+func newItem(e wikipedia.Event, poli wikidata.Politician) *pipeline.Item {
+	name, _ := pipeline.PoliticianName(poli)
+
+	return &pipeline.Item{
+		Event: e,
+		Page:  e.PageTitle(),
+		Subject: pipeline.Subject{
+			Title:      e.SubjectTitle(),
+			Politician: &poli,
+			Name:       name,
+		},
+		Log: logging.With(e.LogFields()...),
+	}
+}
+
+// skipReason returns the reason of a *Skip, or an empty string for other errors
+func skipReason(err error) string {
+	var s *pipeline.Skip
+	if errors.As(err, &s) {
+		return s.Reason
+	}
+	return ""
+}
+
+// newPipeline returns a pipeline for events about Max Mustermann and a politician without name
+func newPipeline(t *testing.T, observers ...pipeline.Observer) (p *pipeline.Pipeline, publisher *recorder) {
+	t.Helper()
+
+	politicians := wikidata.NewPoliticianStore(mustermann, wikidata.Politician{ID: "Q3", WikiPageTitle: "Ohne Namen"})
+
+	mutes, err := mute.New(config.Config{})
+	if err != nil {
+		t.Fatalf("creating mute list: %v", err)
+	}
+
+	publisher = &recorder{}
+
+	return &pipeline.Pipeline{
+		Matcher:   &pipeline.StoreMatcher{Politicians: &politicians},
+		Observers: observers,
+		Filters: []pipeline.Filter{
+			&pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit, wikipedia.TypeLog}},
+			pipeline.DiffFilter{},
+			&pipeline.SizeFilter{MinSize: 100},
+		},
+		Renderer:  fakeRenderer{},
+		Composer:  pipeline.TextComposer{},
+		Publisher: publisher,
+		Controls:  &pipeline.Controls{Mutes: mutes},
+	}, publisher
+}
+
+func TestProcess(t *testing.T) {
+	var tests = []struct {
+		name  string
+		event wikipedia.Event
+		setup func(p *pipeline.Pipeline, publisher *recorder)
+
+		wantMatched bool
+		wantOutcome string
+		wantReason  string
+		wantPosts   int
+	}{
+		{
+			name:  "unknown article",
+			event: streamtest.Edit("Irgendein Artikel", 100, 1000),
+		},
+		{
+			name:        "posted",
+			event:       streamtest.Edit("Max Mustermann", 100, 1000),
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomePosted,
+			wantPosts:   1,
+		},
+		{
+			name:        "no name",
+			event:       streamtest.Edit("Ohne Namen", 100, 1000),
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonNoName,
+		},
+		{
+			name:  "paused",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(p *pipeline.Pipeline, _ *recorder) {
+				p.Controls.Pause()
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonPaused,
+		},
+		{
+			name:  "muted article",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(p *pipeline.Pipeline, _ *recorder) {
+				p.Controls.Mutes.Add(mute.Entry{Kind: mute.KindArticle, Value: "Max_Mustermann"})
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonMuted,
+		},
+		{
+			name:  "muted user",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(p *pipeline.Pipeline, _ *recorder) {
+				p.Controls.Mutes.Add(mute.Entry{Kind: mute.KindUser, Value: "Beispielnutzer"})
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonMuted,
+		},
+		{
+			name:        "event type",
+			event:       streamtest.NewPage("Max Mustermann", 1000),
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonEventType,
+		},
+		{
+			name:        "small edit",
+			event:       streamtest.Edit("Max Mustermann", 100, 150),
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeSkipped,
+			wantReason:  pipeline.ReasonSmallEdit,
+		},
+		{
+			name:  "held",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(p *pipeline.Pipeline, _ *recorder) {
+				p.Moderator = &holdModerator{}
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeHeld,
+		},
+		{
+			name:  "deferred",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(_ *pipeline.Pipeline, publisher *recorder) {
+				publisher.err = fmt.Errorf("%w: global rate limit reached", pipeline.ErrDeferred)
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeDeferred,
+		},
+		{
+			name:  "publishing failed",
+			event: streamtest.Edit("Max Mustermann", 100, 1000),
+			setup: func(_ *pipeline.Pipeline, publisher *recorder) {
+				publisher.err = errors.New("connection refused")
+			},
+			wantMatched: true,
+			wantOutcome: pipeline.OutcomeFailed,
+			wantReason:  pipeline.StagePublish,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, publisher := newPipeline(t)
+			if tt.setup != nil {
+				tt.setup(p, publisher)
+			}
+
+			d, matched := p.Process(context.Background(), tt.event)
+			if matched != tt.wantMatched {
+				t.Fatalf("got matched %v, want %v", matched, tt.wantMatched)
+			}
+			if d.Outcome != tt.wantOutcome || d.Reason != tt.wantReason {
+				t.Errorf("got %s/%s (%v), want %s/%s", d.Outcome, d.Reason, d.Err, tt.wantOutcome, tt.wantReason)
+			}
+			if len(d.Posts) != tt.wantPosts || len(publisher.texts()) != tt.wantPosts {
+				t.Errorf("got %d posts in the decision and %d published, want %d", len(d.Posts), len(publisher.texts()), tt.wantPosts)
+			}
+			if d.Err != nil && strings.Contains(d.Err.Error(), tt.event.User) {
+				t.Errorf("decision %q contains the user %q", d.Err, tt.event.User)
+			}
+		})
+	}
+}
+
+func TestProcessPost(t *testing.T) {
+	p, publisher := newPipeline(t)
+
+	e := streamtest.Edit("Max Mustermann", 100, 1000)
+	diffURL, _ := e.DiffURL()
+
+	d, _ := p.Process(context.Background(), e)
+	if d.Outcome != pipeline.OutcomePosted {
+		t.Fatalf("got outcome %s (%v), want posted", d.Outcome, d.Err)
+	}
+
+	post := publisher.posts[0]
+	if post.ThreadKey != "Max Mustermann" || post.Text != "Änderung beim Wiki-Eintrag zu Max #Mustermann\n"+diffURL {
+		t.Errorf("got post %q with thread key %q", post.Text, post.ThreadKey)
+	}
+	if string(post.Image) != "\x89PNG "+diffURL {
+		t.Errorf("got image %q, want the rendered one", post.Image)
+	}
+	if post.Log == nil || post.Observer {
+		t.Errorf("post must have the log of the item and not be an observer post, got %+v", post)
+	}
+}
+
+func TestProcessNewArticle(t *testing.T) {
+	p, publisher := newPipeline(t)
+	p.Filters[0] = &pipeline.TypeFilter{Types: []string{wikipedia.TypeEdit, wikipedia.TypeNew}}
+
+	erika := wikidata.Politician{ID: "Q2", FirstName: "Erika", LastName: "Musterfrau", WikiPageTitle: "Erika Musterfrau"}
+
+	var lookups []string
+	p.Matcher.(*pipeline.StoreMatcher).Resolve = func(title string) (poli wikidata.Politician, ok bool, err error) {
+		lookups = append(lookups, title)
+		if title == erika.WikiPageTitle {
+			return erika, true, nil
+		}
+		return
+	}
+
+	created := streamtest.NewPage("Erika Musterfrau", 2000)
+	edited := streamtest.Edit("Erika Musterfrau", 2000, 2500)
+	createdURL, _ := created.RevisionURL()
+	diffURL, _ := edited.DiffURL()
+
+	for _, e := range []wikipedia.Event{created, streamtest.NewPage("Irgendein Artikel", 2000), edited} {
+		p.Process(context.Background(), e)
+	}
+
+	want := []string{
+		"Neuer Wiki-Eintrag zu Erika #Musterfrau\n" + createdURL,
+		"Änderung beim Wiki-Eintrag zu Erika #Musterfrau\n" + diffURL,
+	}
+	if got := publisher.texts(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got posts %q, want %q", got, want)
+	}
+	if fmt.Sprint(lookups) != fmt.Sprint([]string{"Erika Musterfrau", "Irgendein Artikel"}) {
+		t.Errorf("looked up %q, want only the new articles", lookups)
+	}
+}
+
+func TestProcessTalkPageLimit(t *testing.T) {
+	p, publisher := newPipeline(t)
+	p.Matcher.(*pipeline.StoreMatcher).TalkPages = true
+	p.Filters = append(p.Filters, &pipeline.TalkPageFilter{MinSize: 100, MinInterval: time.Hour, MaxPerHour: 1})
+
+	var steps = []struct {
+		name       string
+		publishErr error
+
+		wantOutcome string
+	}{
+		{"publishing fails", errors.New("twitter is down"), pipeline.OutcomeFailed},
+		{"slot was given back", nil, pipeline.OutcomePosted},
+		{"slot is used up", nil, pipeline.OutcomeSkipped},
+	}
+
+	for _, s := range steps {
+		publisher.err = s.publishErr
+
+		d, _ := p.Process(context.Background(), talkEdit("Max Mustermann", 100, 1000, "Antwort"))
+		if d.Outcome != s.wantOutcome {
+			t.Errorf("%s: got outcome %s (%v), want %s", s.name, d.Outcome, d.Err, s.wantOutcome)
+		}
+	}
+}
+
+// revertWar returns edits of the article that revert each other, enough for an edit war alert with the default settings
+func revertWar(title string) (edits []wikipedia.Event) {
+	for i := 0; i < 6; i++ {
+		e := streamtest.Edit(title, 1000, 1500)
+		if i%2 == 1 {
+			e.Length.Old, e.Length.New = 1500, 1000
+			e.Comment = "Änderung von Beispielnutzer rückgängig gemacht"
+		}
+		edits = append(edits, e)
+	}
+	return
+}
+
+func TestProcessEditWar(t *testing.T) {
+	var tests = []struct {
+		name   string
+		setup  func(p *pipeline.Pipeline)
+		events []wikipedia.Event
+
+		// wantAlert is the start of the observer post, empty means there must be none
+		wantAlert string
+		// wantReasons are the skip reasons of the events
+		wantReasons []string
+	}{
+		{
+			name:        "edit war",
+			events:      revertWar("Max Mustermann"),
+			wantAlert:   "Möglicher Edit-War beim Wiki-Eintrag zu Max #Mustermann: 6 Änderungen",
+			wantReasons: []string{"", "", "", "", "", ""},
+		},
+		{
+			name: "muted article",
+			setup: func(p *pipeline.Pipeline) {
+				p.Controls.Mutes.Add(mute.Entry{Kind: mute.KindArticle, Value: "Max Mustermann"})
+			},
+			events:      revertWar("Max Mustermann"),
+			wantReasons: []string{"muted", "muted", "muted", "muted", "muted", "muted"},
+		},
+		{
+			name: "paused",
+			setup: func(p *pipeline.Pipeline) {
+				p.Controls.Pause()
+			},
+			events:      revertWar("Max Mustermann"),
+			wantReasons: []string{"paused", "paused", "paused", "paused", "paused", "paused"},
+		},
+		{
+			name:        "protection",
+			events:      []wikipedia.Event{streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "protect")},
+			wantAlert:   "Der Wiki-Eintrag zu Max #Mustermann wurde geschützt",
+			wantReasons: []string{pipeline.ReasonObserved},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, publisher := newPipeline(t, &pipeline.EditWarObserver{Detector: editwar.New(0, 0, 0)})
+			if tt.setup != nil {
+				tt.setup(p)
+			}
+
+			var (
+				reasons  []string
+				observed []pipeline.Published
+			)
+			for _, e := range tt.events {
+				d, _ := p.Process(context.Background(), e)
+				reasons = append(reasons, d.Reason)
+
+				if d.Outcome == pipeline.OutcomePosted {
+					// The post about the edit itself
+					d.Posts = d.Posts[:len(d.Posts)-1]
+				}
+				observed = append(observed, d.Posts...)
+			}
+
+			if fmt.Sprint(reasons) != fmt.Sprint(tt.wantReasons) {
+				t.Errorf("got skip reasons %q, want %q", reasons, tt.wantReasons)
+			}
+
+			var alerts []pipeline.Post
+			for _, post := range publisher.posts {
+				if post.Observer {
+					alerts = append(alerts, post)
+				}
+			}
+			if len(alerts) != len(observed) {
+				t.Errorf("published %d observer posts, but decisions contain %d", len(alerts), len(observed))
+			}
+
+			if tt.wantAlert == "" {
+				if len(alerts) > 0 {
+					t.Errorf("got alert %q, want none", alerts[0].Text)
+				}
+				return
+			}
+
+			if len(alerts) == 0 {
+				t.Fatalf("got no alert, want %q", tt.wantAlert)
+			}
+			if !strings.HasPrefix(alerts[0].Text, tt.wantAlert) {
+				t.Errorf("got alert %q, want it to start with %q", alerts[0].Text, tt.wantAlert)
+			}
+			for _, a := range alerts {
+				if a.ThreadKey != "Max Mustermann" || a.Log == nil {
+					t.Errorf("got observer post with thread key %q and log %v", a.ThreadKey, a.Log)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessProtectionActions(t *testing.T) {
+	var tests = []struct {
+		action string
+
+		wantOutcome string
+		wantText    string
+	}{
+		// New protections are only posted by the observer
+		{"protect", pipeline.OutcomeSkipped, "Der Wiki-Eintrag zu Max #Mustermann wurde geschützt"},
+		{"modify", pipeline.OutcomePosted, "Der Schutz des Wiki-Eintrags zu Max #Mustermann wurde geändert"},
+		{"unprotect", pipeline.OutcomePosted, "Der Schutz des Wiki-Eintrags zu Max #Mustermann wurde aufgehoben"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			p, publisher := newPipeline(t, &pipeline.EditWarObserver{Detector: editwar.New(0, 0, 0)})
+
+			d, _ := p.Process(context.Background(), streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, tt.action))
+			if d.Outcome != tt.wantOutcome {
+				t.Errorf("got outcome %s/%s (%v), want %s", d.Outcome, d.Reason, d.Err, tt.wantOutcome)
+			}
+
+			texts := publisher.texts()
+			if len(texts) != 1 || len(d.Posts) != 1 {
+				t.Fatalf("got posts %q and %d in the decision, want exactly one", texts, len(d.Posts))
+			}
+			if !strings.HasPrefix(texts[0], tt.wantText) {
+				t.Errorf("got post %q, want it to start with %q", texts[0], tt.wantText)
+			}
+			if observer := publisher.posts[0].Observer; observer != (tt.action == "protect") {
+				t.Errorf("got observer post %v for %q", observer, tt.action)
+			}
+		})
+	}
+}
+
+func TestProcessModeration(t *testing.T) {
+	moderator := &holdModerator{}
+
+	p, publisher := newPipeline(t, &pipeline.EditWarObserver{Detector: editwar.New(0, 0, 0)})
+	p.Moderator = moderator
+
+	d, _ := p.Process(context.Background(), streamtest.Log("Max Mustermann", wikipedia.LogTypeProtect, "protect"))
+	if d.Reason != pipeline.ReasonObserved || len(d.Posts) != 0 {
+		t.Errorf("got %s/%s with %d posts, want observed without posts", d.Outcome, d.Reason, len(d.Posts))
+	}
+
+	d, _ = p.Process(context.Background(), streamtest.Edit("Max Mustermann", 100, 1000))
+	if d.Outcome != pipeline.OutcomeHeld || len(d.Posts) != 0 {
+		t.Errorf("got %s/%s with %d posts, want held without posts", d.Outcome, d.Reason, len(d.Posts))
+	}
+
+	if texts := publisher.texts(); len(texts) != 0 {
+		t.Errorf("published %q, but everything should be held", texts)
+	}
+	if len(moderator.held) != 2 || !moderator.held[0].Observer || moderator.held[1].Observer {
+		t.Errorf("got %d held posts, want the observer post and the one about the edit", len(moderator.held))
+	}
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// Pool runs the pipeline with multiple workers, so a slow screenshot doesn't block everything else.
+// All events of an article (including its talk page) are always handled by the same worker,
+// which means they are processed in the order they arrived and threads stay correct.
+//
+// All stages of the pipeline and OnDecision must be safe for concurrent use
+type Pool struct {
+	Pipeline *Pipeline
+
+	// Workers is the number of events that are processed at the same time. Defaults to 4
+	Workers int
+
+	// QueueSize is the number of events that can wait for each worker. Defaults to 25
+	QueueSize int
+
+	// DropWhenFull drops events if the queue of their worker is full. Otherwise reading from
+	// the source is paused until there's space again, which can make the stream fall behind
+	DropWhenFull bool
+
+	mu     sync.Mutex
+	stats  PoolStats
+	queues []chan wikipedia.Event
+}
+
+// PoolStats are counters about the events that went through a pool
+type PoolStats struct {
+	// Queued is the number of events that were put into a queue
+	Queued int64
+	// Processed is the number of events that were processed by a worker
+	Processed int64
+	// Dropped is the number of events that were dropped because their queue was full
+	Dropped int64
+	// Blocked is the number of times reading from the source was paused because a queue was full
+	Blocked int64
+	// BlockedTime is the total time reading from the source was paused
+	BlockedTime time.Duration
+	// Waiting is the number of events that are currently queued
+	Waiting int
+}
+
+// Run processes all events of the source until the channel is closed. Events that are
+// already queued are processed before it returns
+func (p *Pool) Run(ctx context.Context, source Source) {
+	var (
+		workers   = p.Workers
+		queueSize = p.QueueSize
+	)
+	if workers <= 0 {
+		workers = 4
+	}
+	if queueSize <= 0 {
+		queueSize = 25
+	}
+
+	queues := make([]chan wikipedia.Event, workers)
+	for i := range queues {
+		queues[i] = make(chan wikipedia.Event, queueSize)
+	}
+	p.mu.Lock()
+	p.queues = queues
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, q := range queues {
+		wg.Add(1)
+		go func(q <-chan wikipedia.Event) {
+			defer wg.Done()
+
+			for e := range q {
+				p.Pipeline.handle(ctx, e)
+
+				p.count(func(s *PoolStats) { s.Processed++ })
+			}
+		}(q)
+	}
+
+	for e := range source.Events(ctx) {
+		p.enqueue(ctx, queues[queueIndex(e.SubjectTitle(), len(queues))], e)
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
+
+// enqueue puts the event into the queue. If the queue is full, it is either dropped or we wait until there's space
+// or ctx is cancelled
+func (p *Pool) enqueue(ctx context.Context, q chan<- wikipedia.Event, e wikipedia.Event) {
+	select {
+	case q <- e:
+		p.count(func(s *PoolStats) { s.Queued++ })
+		return
+	default:
+	}
+
+	if p.DropWhenFull {
+		p.count(func(s *PoolStats) { s.Dropped++ })
+		poolDropped.Inc()
+		logger.Warn("queue is full, dropping event", e.LogFields()...)
+		return
+	}
+
+	logger.Warn("queue is full, waiting before reading more events", e.LogFields()...)
+
+	start := time.Now()
+	select {
+	case q <- e:
+	case <-ctx.Done():
+		p.count(func(s *PoolStats) { s.Dropped++ })
+		poolDropped.Inc()
+		logger.Warn("stopped waiting for the queue, dropping event", e.LogFields()...)
+		return
+	}
+	blocked := time.Since(start)
+
+	p.count(func(s *PoolStats) {
+		s.Queued++
+		s.Blocked++
+		s.BlockedTime += blocked
+	})
+	poolBlockedSeconds.Add(blocked.Seconds())
+}
+
+func (p *Pool) count(f func(s *PoolStats)) {
+	p.mu.Lock()
+	f(&p.stats)
+	p.mu.Unlock()
+}
+
+// Stats returns the counters of the pool
+func (p *Pool) Stats() (s PoolStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s = p.stats
+	for _, q := range p.queues {
+		s.Waiting += len(q)
+	}
+	return
+}
+
+// queueIndex returns the queue for the article, so all events of the same article end up in the same queue
+func queueIndex(title string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(title))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,202 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+// sliceSource returns its events, even if ctx is cancelled. If wait is set, it's called before each event is sent
+type sliceSource struct {
+	events []wikipedia.Event
+	wait   func(i int)
+}
+
+func (s *sliceSource) Events(ctx context.Context) <-chan wikipedia.Event {
+	c := make(chan wikipedia.Event)
+	go func() {
+		defer close(c)
+
+		for i, e := range s.events {
+			if s.wait != nil {
+				s.wait(i)
+			}
+			c <- e
+		}
+	}()
+	return c
+}
+
+// slowRenderer takes a random time for each screenshot, so workers finish in a different order than they started
+type slowRenderer struct{}
+
+func (slowRenderer) Render(ctx context.Context, item *pipeline.Item) (png []byte, err error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return fakeRenderer{}.Render(ctx, item)
+}
+
+// blockingRenderer doesn't return until release is closed. started receives every item it gets
+type blockingRenderer struct {
+	started chan *pipeline.Item
+	release chan struct{}
+}
+
+func (r *blockingRenderer) Render(ctx context.Context, item *pipeline.Item) (png []byte, err error) {
+	r.started <- item
+	<-r.release
+
+	return fakeRenderer{}.Render(ctx, item)
+}
+
+func TestPoolOrder(t *testing.T) {
+	p, publisher := newPipeline(t)
+	p.Renderer = slowRenderer{}
+
+	var politicians []wikidata.Politician
+	for i := 0; i < 8; i++ {
+		politicians = append(politicians, wikidata.Politician{
+			ID:            fmt.Sprintf("Q%d", 100+i),
+			FirstName:     "Max",
+			LastName:      fmt.Sprintf("Mustermann%d", i),
+			WikiPageTitle: fmt.Sprintf("Max Mustermann%d", i),
+		})
+	}
+	store := wikidata.NewPoliticianStore(politicians...)
+	p.Matcher = &pipeline.StoreMatcher{Politicians: &store}
+
+	var (
+		source sliceSource
+		want   = make(map[string][]string)
+	)
+	for i := 0; i < 10; i++ {
+		for _, poli := range politicians {
+			e := streamtest.Edit(poli.WikiPageTitle, 100, 1000)
+			source.events = append(source.events, e)
+
+			diffURL, _ := e.DiffURL()
+			want[poli.WikiPageTitle] = append(want[poli.WikiPageTitle], diffURL)
+		}
+	}
+
+	pool := &pipeline.Pool{Pipeline: p, Workers: 4}
+	pool.Run(context.Background(), &source)
+
+	var got = make(map[string][]string)
+	for _, post := range publisher.posts {
+		got[post.ThreadKey] = append(got[post.ThreadKey], string(post.Image))
+	}
+
+	for title, diffURLs := range want {
+		var wantImages []string
+		for _, u := range diffURLs {
+			wantImages = append(wantImages, "\x89PNG "+u)
+		}
+		if fmt.Sprint(got[title]) != fmt.Sprint(wantImages) {
+			t.Errorf("posts about %q are out of order:\ngot  %q\nwant %q", title, got[title], wantImages)
+		}
+	}
+
+	if s := pool.Stats(); s.Queued != int64(len(source.events)) || s.Processed != int64(len(source.events)) || s.Dropped != 0 {
+		t.Errorf("got stats %+v, want all %d events queued and processed", s, len(source.events))
+	}
+}
+
+func TestPoolDropWhenFull(t *testing.T) {
+	p, publisher := newPipeline(t)
+
+	renderer := &blockingRenderer{started: make(chan *pipeline.Item, 10), release: make(chan struct{})}
+	p.Renderer = renderer
+
+	var source = sliceSource{
+		wait: func(i int) {
+			// Only go on once the worker is busy with the first event, so the second one fills the queue
+			if i == 1 {
+				<-renderer.started
+			}
+		},
+	}
+	for i := 0; i < 5; i++ {
+		source.events = append(source.events, streamtest.Edit("Max Mustermann", 100, 1000))
+	}
+
+	pool := &pipeline.Pool{Pipeline: p, Workers: 1, QueueSize: 1, DropWhenFull: true}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(context.Background(), &source)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Dropped < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("events weren't dropped, got stats %+v", pool.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(renderer.release)
+	<-done
+
+	s := pool.Stats()
+	if s.Queued != 2 || s.Dropped != 3 || s.Processed != 2 || s.Blocked != 0 {
+		t.Errorf("got stats %+v, want 2 queued and processed events and 3 dropped ones", s)
+	}
+	if len(publisher.posts) != 2 {
+		t.Errorf("got %d posts, want 2", len(publisher.posts))
+	}
+}
+
+func TestPoolCancelWhileBlocked(t *testing.T) {
+	p, _ := newPipeline(t)
+
+	renderer := &blockingRenderer{started: make(chan *pipeline.Item, 10), release: make(chan struct{})}
+	p.Renderer = renderer
+
+	var source = sliceSource{
+		wait: func(i int) {
+			if i == 1 {
+				<-renderer.started
+			}
+		},
+	}
+	for i := 0; i < 3; i++ {
+		source.events = append(source.events, streamtest.Edit("Max Mustermann", 100, 1000))
+	}
+
+	pool := &pipeline.Pool{Pipeline: p, Workers: 1, QueueSize: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx, &source)
+	}()
+
+	// The third event waits for space in the queue, which only frees up once the renderer returns
+	for pool.Stats().Queued < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Dropped < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("still waiting for the queue after the context was cancelled, got stats %+v", pool.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(renderer.release)
+	<-done
+
+	if s := pool.Stats(); s.Queued != 2 || s.Processed != 2 {
+		t.Errorf("got stats %+v, want the queued events to be processed", s)
+	}
+}
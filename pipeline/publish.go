@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// TwitterPublisher posts to twitter. Posts with the same thread key are added to a thread
+// if the last one was posted within ThreadWindow
+type TwitterPublisher struct {
+	Client     *twitter.Client
+	ScreenName string
+
+	// ThreadWindow defaults to two hours
+	ThreadWindow time.Duration
+
+	mu   sync.Mutex
+	last map[string]lastInfo
+}
+
+type lastInfo struct {
+	TweetID int64
+	Time    time.Time
+}
+
+func (p *TwitterPublisher) Publish(ctx context.Context, post Post) (pub Published, err error) {
+	var mediaIDs []int64
+	if len(post.Image) > 0 {
+		media, _, err := p.Client.Media.Upload(post.Image, "image/png")
+		if err != nil {
+			countTwitterError("media/upload", err)
+			return pub, fmt.Errorf("uploading image: %w", err)
+		}
+		mediaIDs = append(mediaIDs, media.MediaID)
+	}
+
+	var window = p.ThreadWindow
+	if window <= 0 {
+		window = 2 * time.Hour
+	}
+
+	// If we tweeted about this recently, add it in a thread
+	var text = post.Text
+	p.mu.Lock()
+	li, ok := p.last[post.ThreadKey]
+	p.mu.Unlock()
+	if ok && time.Since(li.Time) < window {
+		pub.InReplyTo = li.TweetID
+		if post.FollowupText != "" {
+			text = post.FollowupText
+		}
+	}
+
+	t, _, err := p.Client.Statuses.Update(text, &twitter.StatusUpdateParams{
+		MediaIds:          mediaIDs,
+		InReplyToStatusID: pub.InReplyTo,
+	})
+	if err != nil {
+		countTwitterError("statuses/update", err)
+		return pub, fmt.Errorf("sending tweet: %w", err)
+	}
+
+	// Save this info for the next tweet
+	p.mu.Lock()
+	if p.last == nil {
+		p.last = make(map[string]lastInfo)
+	}
+	p.last[post.ThreadKey] = lastInfo{
+		TweetID: t.ID,
+		Time:    time.Now(),
+	}
+	p.mu.Unlock()
+
+	pub.ID = t.ID
+	pub.URL = fmt.Sprintf("https://twitter.com/%s/status/%s", p.ScreenName, t.IDStr)
+
+	return
+}
@@ -0,0 +1,260 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/xarantolus/poliwiki/bot/twittertest"
+)
+
+func newTwitterPublisher(srv *twittertest.Server) *TwitterPublisher {
+	return &TwitterPublisher{
+		Client:     srv.Client(),
+		ScreenName: srv.User.ScreenName,
+	}
+}
+
+func TestTwitterPublisherImage(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	p := newTwitterPublisher(srv)
+
+	var image = []byte("\x89PNG kein echtes Bild")
+	pub, err := p.Publish(context.Background(), Post{
+		ThreadKey: "Max Mustermann",
+		Text:      "Änderung beim Wiki-Eintrag zu Max #Mustermann",
+		Image:     image,
+	})
+	if err != nil {
+		t.Fatalf("publishing failed: %v", err)
+	}
+
+	tweets := srv.Tweets()
+	if len(tweets) != 1 {
+		t.Fatalf("got %d tweets, want 1", len(tweets))
+	}
+	if want := fmt.Sprintf("https://twitter.com/politischeswiki/status/%d", tweets[0].ID); pub.URL != want {
+		t.Errorf("got URL %q, want %q", pub.URL, want)
+	}
+	if len(tweets[0].MediaIDs) != 1 {
+		t.Fatalf("got media IDs %v, want one", tweets[0].MediaIDs)
+	}
+
+	m, ok := srv.Media(tweets[0].MediaIDs[0])
+	if !ok {
+		t.Fatalf("media %d was not uploaded", tweets[0].MediaIDs[0])
+	}
+	if !m.Finalized || m.MediaType != "image/png" || !bytes.Equal(m.Data, image) {
+		t.Errorf("got media %+v, want finalized PNG with the image", m)
+	}
+}
+
+func TestTwitterPublisherThread(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	p := newTwitterPublisher(srv)
+
+	var posts = []Post{
+		{ThreadKey: "Max Mustermann", Text: "Erste Änderung", FollowupText: "Noch eine Änderung"},
+		{ThreadKey: "Erika Mustermann", Text: "Andere Seite"},
+		{ThreadKey: "Max Mustermann", Text: "Zweite Änderung", FollowupText: "Noch eine Änderung"},
+		{ThreadKey: "Max Mustermann", Text: "Dritte Änderung"},
+	}
+
+	var pubs []Published
+	for _, post := range posts {
+		pub, err := p.Publish(context.Background(), post)
+		if err != nil {
+			t.Fatalf("publishing %q failed: %v", post.Text, err)
+		}
+		pubs = append(pubs, pub)
+	}
+
+	if pubs[1].InReplyTo != 0 {
+		t.Errorf("post about another article replied to %d", pubs[1].InReplyTo)
+	}
+	if pubs[2].InReplyTo != pubs[0].ID || pubs[3].InReplyTo != pubs[2].ID {
+		t.Errorf("posts about the same article are not a thread: %+v", pubs)
+	}
+
+	thread := srv.Thread(pubs[0].ID)
+
+	var texts []string
+	for _, tw := range thread {
+		texts = append(texts, tw.Text)
+	}
+	// Without FollowupText, replies use Text
+	if want := []string{"Erste Änderung", "Noch eine Änderung", "Dritte Änderung"}; fmt.Sprint(texts) != fmt.Sprint(want) {
+		t.Errorf("got thread %q, want %q", texts, want)
+	}
+}
+
+func TestTwitterPublisherThreadWindow(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	p := newTwitterPublisher(srv)
+	p.ThreadWindow = time.Nanosecond
+
+	for _, text := range []string{"Erste Änderung", "Zweite Änderung"} {
+		pub, err := p.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: text, FollowupText: "Noch eine Änderung"})
+		if err != nil {
+			t.Fatalf("publishing failed: %v", err)
+		}
+		if pub.InReplyTo != 0 {
+			t.Errorf("%q replied to %d after the thread window was over", text, pub.InReplyTo)
+		}
+	}
+}
+
+func TestTwitterPublisherErrors(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	p := newTwitterPublisher(srv)
+
+	first, err := p.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Erste Änderung"})
+	if err != nil {
+		t.Fatalf("publishing failed: %v", err)
+	}
+
+	var tests = []struct {
+		name     string
+		endpoint string
+		err      twittertest.Error
+		image    []byte
+	}{
+		{"rate limit", twittertest.EndpointUpdate, twittertest.RateLimitError, nil},
+		{"duplicate", twittertest.EndpointUpdate, twittertest.Error{Status: 403, Code: twittertest.CodeDuplicateStatus, Message: "Status is a duplicate."}, nil},
+		{"upload", twittertest.EndpointUpload, twittertest.Error{Status: 400, Code: 324, Message: "Invalid media"}, []byte("png")},
+	}
+
+	for _, tt := range tests {
+		srv.FailNext(tt.endpoint, tt.err)
+
+		_, err := p.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Zweite Änderung", Image: tt.image})
+
+		var apiErr twitter.APIError
+		if !errors.As(err, &apiErr) || len(apiErr.Errors) == 0 || apiErr.Errors[0].Code != tt.err.Code {
+			t.Errorf("%s: got error %v, want the API error with code %d", tt.name, err, tt.err.Code)
+		}
+	}
+
+	// Failed posts don't change the thread
+	pub, err := p.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Zweite Änderung"})
+	if err != nil {
+		t.Fatalf("publishing failed: %v", err)
+	}
+	if pub.InReplyTo != first.ID {
+		t.Errorf("got reply to %d, want %d", pub.InReplyTo, first.ID)
+	}
+	if n := len(srv.Tweets()); n != 2 {
+		t.Errorf("got %d tweets, want 2", n)
+	}
+}
+
+func TestRateLimiterTwitterLimit(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	var controls Controls
+	r := &RateLimiter{
+		Publisher: newTwitterPublisher(srv),
+		Overflow:  LimitQueue,
+		Controls:  &controls,
+	}
+
+	srv.FailNext(twittertest.EndpointUpdate, twittertest.RateLimitError)
+
+	_, err := r.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Erste Änderung"})
+	if !errors.Is(err, ErrDeferred) {
+		t.Fatalf("got error %v, want ErrDeferred", err)
+	}
+
+	s := r.Stats()
+	if s.Queued != 1 || s.BlockedUntil.IsZero() {
+		t.Fatalf("got stats %+v, want one queued post and a block", s)
+	}
+
+	// While blocked, everything else waits too
+	_, err = r.Publish(context.Background(), Post{ThreadKey: "Erika Mustermann", Text: "Andere Seite"})
+	if !errors.Is(err, ErrDeferred) {
+		t.Fatalf("got error %v, want ErrDeferred", err)
+	}
+
+	r.mu.Lock()
+	r.blockedUntil = time.Time{}
+	r.mu.Unlock()
+
+	controls.Pause()
+	r.drain(context.Background())
+	if n := len(srv.Tweets()); n != 0 {
+		t.Fatalf("got %d tweets while paused, want none", n)
+	}
+
+	controls.Resume()
+	r.drain(context.Background())
+
+	var texts []string
+	for _, tw := range srv.Tweets() {
+		texts = append(texts, tw.Text)
+	}
+	if want := []string{"Erste Änderung", "Andere Seite"}; fmt.Sprint(texts) != fmt.Sprint(want) {
+		t.Errorf("got tweets %q, want %q", texts, want)
+	}
+	if s := r.Stats(); s.Queued != 0 {
+		t.Errorf("%d posts are still queued", s.Queued)
+	}
+}
+
+func TestRateLimiterTwitterLimitDrain(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	r := &RateLimiter{
+		Publisher: newTwitterPublisher(srv),
+		Overflow:  LimitQueue,
+		queue:     []Post{{ThreadKey: "Max Mustermann", Text: "Erste Änderung"}},
+	}
+
+	srv.FailNext(twittertest.EndpointUpdate, twittertest.RateLimitError)
+	r.drain(context.Background())
+
+	// The post goes back to the front of the queue
+	if s := r.Stats(); s.Queued != 1 || s.BlockedUntil.IsZero() {
+		t.Errorf("got stats %+v, want the post queued again and a block", s)
+	}
+	if n := len(srv.Tweets()); n != 0 {
+		t.Errorf("got %d tweets, want none", n)
+	}
+}
+
+func TestRateLimiterTwitterLimitDrop(t *testing.T) {
+	srv := twittertest.NewServer("politischeswiki")
+	defer srv.Close()
+
+	r := &RateLimiter{
+		Publisher: newTwitterPublisher(srv),
+		Overflow:  LimitDrop,
+	}
+
+	srv.FailNext(twittertest.EndpointUpdate, twittertest.RateLimitError)
+
+	_, err := r.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Erste Änderung"})
+	if !isTwitterLimit(err) {
+		t.Fatalf("got error %v, want the rate limit error of twitter", err)
+	}
+
+	_, err = r.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Zweite Änderung"})
+	var s *Skip
+	if !errors.As(err, &s) || s.Reason != ReasonRateLimited {
+		t.Errorf("got error %v, want a rate limit skip", err)
+	}
+}
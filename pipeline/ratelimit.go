@@ -0,0 +1,543 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// ErrDeferred is returned by publishers that publish the post later, e.g. because of a rate limit
+var ErrDeferred = errors.New("post will be published later")
+
+// What the RateLimiter does with posts that exceed a limit
+const (
+	LimitDrop   = "drop"
+	LimitQueue  = "queue"
+	LimitDigest = "digest"
+)
+
+// Twitter error codes that mean we posted too much
+const (
+	twitterCodeRateLimit   = 88
+	twitterCodeDailyLimit  = 185
+	twitterBlockedDuration = 15 * time.Minute
+)
+
+// twitterLimitEndpoints are checked with the rate limit API. Twitter doesn't report every endpoint there,
+// so errors of the publisher are also used for finding out whether we hit a limit
+var twitterLimitEndpoints = []string{"/statuses/update", "/statuses/retweet/:id"}
+
+// RateLimit is a token bucket: up to Burst posts can be published at once, then PerHour posts per hour.
+// A PerHour of zero means there is no limit
+type RateLimit struct {
+	PerHour float64
+	Burst   int
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst <= 0 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// fill adds the tokens since the last call
+func (b *bucket) fill(l RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = l.burst()
+	} else {
+		b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.last).Hours()*l.PerHour)
+	}
+	b.last = now
+}
+
+// RateLimiter is a publisher that limits how many posts are published by another publisher,
+// both in total and per thread key, i.e. per article. Run must be called for queued posts and digests.
+// Posts with the same thread key are never published at the same time, so threads stay in order
+type RateLimiter struct {
+	Publisher Publisher
+
+	Global     RateLimit
+	PerArticle RateLimit
+
+	// Overflow is LimitDrop, LimitQueue or LimitDigest. Dropped posts return a *Skip,
+	// queued ones and the ones that end up in a digest ErrDeferred
+	Overflow string
+
+	// QueueSize is the maximum number of queued posts and of posts in the next digest, defaults to 50
+	QueueSize int
+
+	// DigestInterval is the time between two digests, defaults to one hour
+	DigestInterval time.Duration
+
+	// Client is used for asking Twitter about its own limits every TwitterCheckInterval, which defaults
+	// to 15 minutes. Optional, but if Twitter returns a rate limit error, publishing is paused anyway
+	Client               *twitter.Client
+	TwitterCheckInterval time.Duration
+
+	// Controls stop queued posts and digests from being published while the pipeline is paused, optional
+	Controls *Controls
+
+	mu       sync.Mutex
+	global   bucket
+	articles map[string]*bucket
+	queue    []Post
+	digest   []Post
+
+	// publishing contains the thread keys of posts that are being published right now,
+	// idle is signalled whenever one of them is done
+	publishing map[string]bool
+	idle       *sync.Cond
+
+	// blockedUntil is set if Twitter doesn't allow more posts
+	blockedUntil time.Time
+
+	// now returns the current time, tests can replace it. Defaults to time.Now
+	now func() time.Time
+}
+
+// RateLimitStats describe what the RateLimiter is currently holding back
+type RateLimitStats struct {
+	// Queued is the number of posts that wait in the queue
+	Queued int
+	// Digest is the number of posts that will be part of the next digest
+	Digest int
+
+	// BlockedUntil is the time Twitter allows posting again, zero if it's allowed now
+	BlockedUntil time.Time
+}
+
+// Stats returns the current stats
+func (r *RateLimiter) Stats() (s RateLimitStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s.Queued = len(r.queue)
+	s.Digest = len(r.digest)
+	if r.clock().Before(r.blockedUntil) {
+		s.BlockedUntil = r.blockedUntil
+	}
+
+	return
+}
+
+func (r *RateLimiter) Publish(ctx context.Context, post Post) (pub Published, err error) {
+	r.mu.Lock()
+	r.acquire(post.ThreadKey)
+	defer r.release(post.ThreadKey)
+
+	// Queued posts are older, so they go first
+	if r.Overflow == LimitQueue && len(r.queue) > 0 {
+		defer r.mu.Unlock()
+		return pub, r.overflow(post, "posts are waiting in the queue")
+	}
+
+	reason, ok := r.allow(post, r.clock())
+	if !ok {
+		defer r.mu.Unlock()
+		return pub, r.overflow(post, reason)
+	}
+	r.mu.Unlock()
+
+	pub, err = r.Publisher.Publish(ctx, post)
+	if isTwitterLimit(err) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.block(r.clock().Add(twitterBlockedDuration), "twitter returned a rate limit error")
+		if r.Overflow != LimitDrop {
+			return pub, r.overflow(post, "twitter returned a rate limit error")
+		}
+	}
+
+	return
+}
+
+// acquire waits until no other post with the thread key is being published and marks the key as busy.
+// The lock must be held, it's released while waiting
+func (r *RateLimiter) acquire(key string) {
+	if r.publishing == nil {
+		r.publishing = make(map[string]bool)
+		r.idle = sync.NewCond(&r.mu)
+	}
+
+	for r.publishing[key] {
+		r.idle.Wait()
+	}
+	r.publishing[key] = true
+}
+
+// release marks the thread key as not busy anymore. The lock must not be held
+func (r *RateLimiter) release(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.publishing, key)
+	r.idle.Broadcast()
+}
+
+// clock returns the current time
+func (r *RateLimiter) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// allow takes a token from both buckets of the post if both have one. The lock must be held
+func (r *RateLimiter) allow(post Post, now time.Time) (reason string, ok bool) {
+	if now.Before(r.blockedUntil) {
+		return fmt.Sprintf("twitter doesn't allow posting until %s", r.blockedUntil.Format(time.RFC3339)), false
+	}
+
+	if r.Global.PerHour > 0 {
+		r.global.fill(r.Global, now)
+		if r.global.tokens < 1 {
+			return "global rate limit reached", false
+		}
+	}
+
+	var b *bucket
+	if r.PerArticle.PerHour > 0 && !post.Observer {
+		if r.articles == nil {
+			r.articles = make(map[string]*bucket)
+		}
+
+		b = r.articles[post.ThreadKey]
+		if b == nil {
+			b = &bucket{}
+			r.articles[post.ThreadKey] = b
+		}
+
+		b.fill(r.PerArticle, now)
+		if b.tokens < 1 {
+			return fmt.Sprintf("rate limit for %q reached", post.ThreadKey), false
+		}
+	}
+
+	if r.Global.PerHour > 0 {
+		r.global.tokens--
+	}
+	if b != nil {
+		b.tokens--
+	}
+
+	return "", true
+}
+
+// overflow handles a post that exceeded a limit. The lock must be held
+func (r *RateLimiter) overflow(post Post, reason string) error {
+	var queueSize = r.queueSize()
+
+	switch {
+	case r.Overflow == LimitQueue && len(r.queue) < queueSize:
+		r.queue = append(r.queue, post)
+		rateLimited.With("queued").Inc()
+		return fmt.Errorf("%w: %s, queued post about %q", ErrDeferred, reason, post.ThreadKey)
+	case r.Overflow == LimitDigest && len(r.digest) < queueSize:
+		r.digest = append(r.digest, post)
+		rateLimited.With("digest").Inc()
+		return fmt.Errorf("%w: %s, adding %q to the next digest", ErrDeferred, reason, post.ThreadKey)
+	case r.Overflow == LimitQueue:
+		reason = "queue is full"
+	case r.Overflow == LimitDigest:
+		reason = "digest is full"
+	}
+
+	rateLimited.With("dropped").Inc()
+	return skipf(ReasonRateLimited, "%s, dropping post about %q", reason, post.ThreadKey)
+}
+
+func (r *RateLimiter) queueSize() int {
+	if r.QueueSize <= 0 {
+		return 50
+	}
+	return r.QueueSize
+}
+
+// block stops publishing until the given time. The lock must be held
+func (r *RateLimiter) block(until time.Time, reason string) {
+	if until.After(r.blockedUntil) {
+		logger.Warn("pausing posts because of twitter limits", "until", until, "reason", reason)
+		r.blockedUntil = until
+	}
+}
+
+// isTwitterLimit returns whether err says that we posted too much
+func isTwitterLimit(err error) bool {
+	var apiErr twitter.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, e := range apiErr.Errors {
+		if e.Code == twitterCodeRateLimit || e.Code == twitterCodeDailyLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// Run publishes queued posts and digests until ctx is cancelled
+func (r *RateLimiter) Run(ctx context.Context) {
+	var digestInterval = r.DigestInterval
+	if digestInterval <= 0 {
+		digestInterval = time.Hour
+	}
+
+	drain := time.NewTicker(10 * time.Second)
+	defer drain.Stop()
+
+	digest := time.NewTicker(digestInterval)
+	defer digest.Stop()
+
+	var check <-chan time.Time
+	if r.Client != nil {
+		var checkInterval = r.TwitterCheckInterval
+		if checkInterval <= 0 {
+			checkInterval = 15 * time.Minute
+		}
+
+		t := time.NewTicker(checkInterval)
+		defer t.Stop()
+		check = t.C
+
+		r.checkTwitter()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-drain.C:
+			r.drain(ctx)
+			r.cleanup()
+		case <-digest.C:
+			r.publishDigest(ctx)
+		case <-check:
+			r.checkTwitter()
+		}
+	}
+}
+
+// drain publishes queued posts as long as the limits allow it
+func (r *RateLimiter) drain(ctx context.Context) {
+	for {
+		if r.paused() {
+			return
+		}
+
+		r.mu.Lock()
+		post, ok := r.next(r.clock())
+		if ok {
+			r.acquire(post.ThreadKey)
+		}
+		r.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		pub, err := r.Publisher.Publish(ctx, post)
+		r.release(post.ThreadKey)
+		if err != nil {
+			if isTwitterLimit(err) {
+				// Try again later
+				r.mu.Lock()
+				r.block(r.clock().Add(twitterBlockedDuration), "twitter returned a rate limit error")
+				r.queue = append([]Post{post}, r.queue...)
+				r.mu.Unlock()
+				return
+			}
+
+			post.log().Error("publishing queued post failed", "thread_key", post.ThreadKey, "err", err)
+			continue
+		}
+
+		posts.Inc()
+		post.log().Info("posted queued post", "thread_key", post.ThreadKey, "url", pub.URL, "in_reply_to", pub.InReplyTo)
+	}
+}
+
+func (r *RateLimiter) paused() bool {
+	return r.Controls != nil && r.Controls.Paused()
+}
+
+// next removes and returns the oldest queued post the limits allow. Posts about the same article keep
+// their order, and posts whose thread key is being published right now wait for that. The lock must be held
+func (r *RateLimiter) next(now time.Time) (post Post, ok bool) {
+	var waiting = make(map[string]bool)
+
+	for i, p := range r.queue {
+		if waiting[p.ThreadKey] {
+			continue
+		}
+		if r.publishing[p.ThreadKey] {
+			waiting[p.ThreadKey] = true
+			continue
+		}
+
+		_, ok = r.allow(p, now)
+		if ok {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			return p, true
+		}
+
+		if (r.Global.PerHour > 0 && r.global.tokens < 1) || now.Before(r.blockedUntil) {
+			// Nothing else is allowed either
+			return
+		}
+		waiting[p.ThreadKey] = true
+	}
+
+	return
+}
+
+// cleanup removes buckets of articles that are full again, they behave like new ones
+func (r *RateLimiter) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock()
+	for key, b := range r.articles {
+		b.fill(r.PerArticle, now)
+		if b.tokens >= r.PerArticle.burst() {
+			delete(r.articles, key)
+		}
+	}
+}
+
+// publishDigest publishes one post that mentions all articles in the digest
+func (r *RateLimiter) publishDigest(ctx context.Context) {
+	if r.paused() {
+		return
+	}
+
+	r.mu.Lock()
+	if len(r.digest) == 0 {
+		r.mu.Unlock()
+		return
+	}
+
+	now := r.clock()
+	if now.Before(r.blockedUntil) {
+		r.mu.Unlock()
+		return
+	}
+	// The digest only counts towards the global limit. If it's reached, we try again next time
+	if r.Global.PerHour > 0 {
+		r.global.fill(r.Global, now)
+		if r.global.tokens < 1 {
+			r.mu.Unlock()
+			return
+		}
+		r.global.tokens--
+	}
+
+	var digest = r.digest
+	r.digest = nil
+	r.mu.Unlock()
+
+	pub, err := r.Publisher.Publish(ctx, digestPost(digest))
+	if err != nil {
+		logger.Error("publishing digest failed", "posts", len(digest), "err", err)
+
+		r.mu.Lock()
+		if isTwitterLimit(err) {
+			r.block(r.clock().Add(twitterBlockedDuration), "twitter returned a rate limit error")
+		}
+		r.digest = append(digest, r.digest...)
+		if len(r.digest) > r.queueSize() {
+			r.digest = r.digest[:r.queueSize()]
+		}
+		r.mu.Unlock()
+		return
+	}
+
+	posts.Inc()
+	logger.Info("posted digest", "posts", len(digest), "url", pub.URL)
+
+	// Every event should be traceable to the post it ended up in
+	for _, p := range digest {
+		p.log().Debug("mentioned in digest", "thread_key", p.ThreadKey, "url", pub.URL)
+	}
+}
+
+// digestPost lists the articles of the posts, the ones with most posts first
+func digestPost(digest []Post) Post {
+	var (
+		counts = make(map[string]int)
+		order  []string
+	)
+	for _, p := range digest {
+		if counts[p.ThreadKey] == 0 {
+			order = append(order, p.ThreadKey)
+		}
+		counts[p.ThreadKey]++
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return counts[order[i]] > counts[order[j]]
+	})
+
+	var text = "Außerdem geändert:"
+	for i, key := range order {
+		line := "\n" + key
+		if c := counts[key]; c > 1 {
+			line += fmt.Sprintf(" (%d×)", c)
+		}
+
+		// There must be enough space left for mentioning the ones that don't fit
+		var rest string
+		if i < len(order)-1 {
+			rest = fmt.Sprintf("\n… und %d weitere", len(order)-i-1)
+		}
+		if len([]rune(text+line+rest)) > 280 {
+			text += fmt.Sprintf("\n… und %d weitere", len(order)-i)
+			break
+		}
+		text += line
+	}
+
+	return Post{
+		ThreadKey: "rate_limit_digest",
+		Text:      strings.TrimSpace(text),
+	}
+}
+
+// checkTwitter asks Twitter whether we reached one of its limits
+func (r *RateLimiter) checkTwitter() {
+	limits, _, err := r.Client.RateLimits.Status(&twitter.RateLimitParams{
+		Resources: []string{"statuses"},
+	})
+	if err != nil {
+		countTwitterError("application/rate_limit_status", err)
+		logger.Warn("checking twitter rate limits failed", "err", err)
+		return
+	}
+	if limits.Resources == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, endpoint := range twitterLimitEndpoints {
+		res, ok := limits.Resources.Statuses[endpoint]
+		if !ok || res == nil || res.Remaining > 0 {
+			continue
+		}
+
+		r.block(time.Unix(int64(res.Reset), 0), "twitter limit of "+endpoint+" reached")
+	}
+}
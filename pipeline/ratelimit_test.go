@@ -0,0 +1,467 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for the RateLimiter that only moves when told to
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+// postRecorder remembers the texts of all posts. If block is set, the first post isn't published until it's closed
+type postRecorder struct {
+	block   chan struct{}
+	started chan struct{}
+
+	mu    sync.Mutex
+	texts []string
+	calls int
+}
+
+func (p *postRecorder) Publish(ctx context.Context, post Post) (pub Published, err error) {
+	p.mu.Lock()
+	p.calls++
+	first := p.calls == 1
+	p.mu.Unlock()
+
+	if first && p.block != nil {
+		close(p.started)
+		<-p.block
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.texts = append(p.texts, post.Text)
+	pub.ID = int64(len(p.texts))
+	return
+}
+
+func (p *postRecorder) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.texts...)
+}
+
+// outcome describes the result of RateLimiter.Publish: "posted", "deferred" or "dropped"
+func outcome(t *testing.T, err error) string {
+	t.Helper()
+
+	var s *Skip
+	switch {
+	case err == nil:
+		return "posted"
+	case errors.Is(err, ErrDeferred):
+		return "deferred"
+	case errors.As(err, &s) && s.Reason == ReasonRateLimited:
+		return "dropped"
+	default:
+		t.Fatalf("unexpected error: %v", err)
+		return ""
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	type step struct {
+		after    time.Duration
+		key      string
+		observer bool
+
+		want string
+	}
+
+	var tests = []struct {
+		name       string
+		global     RateLimit
+		perArticle RateLimit
+		steps      []step
+	}{
+		{
+			name: "no limits",
+			steps: []step{
+				{key: "A", want: "posted"},
+				{key: "A", want: "posted"},
+				{key: "A", want: "posted"},
+			},
+		},
+		{
+			name:   "global burst and refill",
+			global: RateLimit{PerHour: 1, Burst: 2},
+			steps: []step{
+				{key: "A", want: "posted"},
+				{key: "B", want: "posted"},
+				{key: "C", want: "dropped"},
+				{after: 30 * time.Minute, key: "C", want: "dropped"},
+				{after: 30 * time.Minute, key: "C", want: "posted"},
+			},
+		},
+		{
+			name:       "per article",
+			perArticle: RateLimit{PerHour: 2},
+			steps: []step{
+				{key: "A", want: "posted"},
+				{key: "A", want: "dropped"},
+				{key: "B", want: "posted"},
+				{after: 30 * time.Minute, key: "A", want: "posted"},
+			},
+		},
+		{
+			name:       "limited article doesn't use a global token",
+			global:     RateLimit{PerHour: 1, Burst: 2},
+			perArticle: RateLimit{PerHour: 1},
+			steps: []step{
+				{key: "A", want: "posted"},
+				{key: "A", want: "dropped"},
+				{key: "B", want: "posted"},
+				{key: "C", want: "dropped"},
+			},
+		},
+		{
+			name:       "observer posts ignore the article limit",
+			perArticle: RateLimit{PerHour: 1},
+			steps: []step{
+				{key: "A", want: "posted"},
+				{key: "A", observer: true, want: "posted"},
+				{key: "A", want: "dropped"},
+			},
+		},
+		{
+			name:   "observer posts count towards the global limit",
+			global: RateLimit{PerHour: 1},
+			steps: []step{
+				{key: "A", observer: true, want: "posted"},
+				{key: "B", want: "dropped"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			r := &RateLimiter{
+				Publisher:  &postRecorder{},
+				Global:     tt.global,
+				PerArticle: tt.perArticle,
+				Overflow:   LimitDrop,
+				now:        clock.now,
+			}
+
+			for i, s := range tt.steps {
+				clock.advance(s.after)
+
+				_, err := r.Publish(context.Background(), Post{ThreadKey: s.key, Observer: s.observer})
+				if got := outcome(t, err); got != s.want {
+					t.Errorf("step %d: got %s (%v), want %s", i+1, got, err, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterNext(t *testing.T) {
+	var tests = []struct {
+		name       string
+		global     RateLimit
+		perArticle RateLimit
+		publishing []string
+		blocked    bool
+		queue      []string
+
+		want     []string
+		wantLeft []string
+	}{
+		{
+			name:  "oldest first",
+			queue: []string{"A1", "B1", "A2"},
+			want:  []string{"A1", "B1", "A2"},
+		},
+		{
+			name:       "article limit keeps the order of the article",
+			perArticle: RateLimit{PerHour: 1},
+			queue:      []string{"A1", "A2", "B1", "A3"},
+			want:       []string{"A1", "B1"},
+			wantLeft:   []string{"A2", "A3"},
+		},
+		{
+			name:     "global limit",
+			global:   RateLimit{PerHour: 1, Burst: 2},
+			queue:    []string{"A1", "B1", "C1"},
+			want:     []string{"A1", "B1"},
+			wantLeft: []string{"C1"},
+		},
+		{
+			name:       "thread key that is being published",
+			publishing: []string{"A"},
+			queue:      []string{"A1", "B1", "A2"},
+			want:       []string{"B1"},
+			wantLeft:   []string{"A1", "A2"},
+		},
+		{
+			name:     "blocked by twitter",
+			blocked:  true,
+			queue:    []string{"A1"},
+			wantLeft: []string{"A1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			r := &RateLimiter{
+				Global:     tt.global,
+				PerArticle: tt.perArticle,
+				Overflow:   LimitQueue,
+				publishing: make(map[string]bool),
+				now:        clock.now,
+			}
+			for _, key := range tt.publishing {
+				r.publishing[key] = true
+			}
+			if tt.blocked {
+				r.blockedUntil = clock.now().Add(time.Minute)
+			}
+			// The first letter is the thread key, the rest tells posts of the same thread apart
+			for _, text := range tt.queue {
+				r.queue = append(r.queue, Post{ThreadKey: text[:1], Text: text})
+			}
+
+			var got []string
+			for {
+				post, ok := r.next(clock.now())
+				if !ok {
+					break
+				}
+				got = append(got, post.Text)
+			}
+
+			var left []string
+			for _, p := range r.queue {
+				left = append(left, p.Text)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || fmt.Sprint(left) != fmt.Sprint(tt.wantLeft) {
+				t.Errorf("got %q with %q left, want %q with %q left", got, left, tt.want, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestRateLimiterDigest(t *testing.T) {
+	var tests = []struct {
+		name      string
+		queueSize int
+		after     time.Duration
+		setup     func(r *RateLimiter)
+
+		wantOutcomes []string
+		wantDigest   string
+		wantLeft     int
+	}{
+		{
+			name:         "published once the global limit allows it",
+			after:        time.Hour,
+			wantOutcomes: []string{"posted", "deferred", "deferred", "deferred"},
+			wantDigest:   "Außerdem geändert:\nB (2×)\nC",
+		},
+		{
+			name:         "digest is full",
+			queueSize:    2,
+			after:        time.Hour,
+			wantOutcomes: []string{"posted", "deferred", "deferred", "dropped"},
+			wantDigest:   "Außerdem geändert:\nB\nC",
+		},
+		{
+			name:         "global limit reached",
+			wantOutcomes: []string{"posted", "deferred", "deferred", "deferred"},
+			wantLeft:     3,
+		},
+		{
+			name:  "paused",
+			after: time.Hour,
+			setup: func(r *RateLimiter) {
+				r.Controls = &Controls{}
+				r.Controls.Pause()
+			},
+			wantOutcomes: []string{"posted", "deferred", "deferred", "deferred"},
+			wantLeft:     3,
+		},
+		{
+			name:  "blocked by twitter",
+			after: time.Hour,
+			setup: func(r *RateLimiter) {
+				r.blockedUntil = r.clock().Add(2 * time.Hour)
+			},
+			wantOutcomes: []string{"deferred", "deferred", "deferred", "deferred"},
+			wantLeft:     4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			publisher := &postRecorder{}
+			r := &RateLimiter{
+				Publisher: publisher,
+				Global:    RateLimit{PerHour: 1},
+				Overflow:  LimitDigest,
+				QueueSize: tt.queueSize,
+				now:       clock.now,
+			}
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			var outcomes []string
+			for _, key := range []string{"A", "B", "C", "B"} {
+				_, err := r.Publish(context.Background(), Post{ThreadKey: key, Text: key})
+				outcomes = append(outcomes, outcome(t, err))
+			}
+			if fmt.Sprint(outcomes) != fmt.Sprint(tt.wantOutcomes) {
+				t.Errorf("got outcomes %v, want %v", outcomes, tt.wantOutcomes)
+			}
+
+			published := len(publisher.published())
+
+			clock.advance(tt.after)
+			r.publishDigest(context.Background())
+
+			var digest string
+			if texts := publisher.published(); len(texts) > published {
+				digest = texts[len(texts)-1]
+			}
+			if digest != tt.wantDigest {
+				t.Errorf("got digest %q, want %q", digest, tt.wantDigest)
+			}
+			if s := r.Stats(); s.Digest != tt.wantLeft {
+				t.Errorf("%d posts are left for the next digest, want %d", s.Digest, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestDigestPost(t *testing.T) {
+	var many []string
+	for i := 0; i < 30; i++ {
+		many = append(many, fmt.Sprintf("Ein langer Artikel %02d", i))
+	}
+
+	var tests = []struct {
+		name string
+		keys []string
+
+		wantText   string
+		wantSuffix string
+	}{
+		{
+			name:     "one article",
+			keys:     []string{"Max Mustermann"},
+			wantText: "Außerdem geändert:\nMax Mustermann",
+		},
+		{
+			name:     "most posts first",
+			keys:     []string{"Max Mustermann", "Erika Musterfrau", "Erika Musterfrau"},
+			wantText: "Außerdem geändert:\nErika Musterfrau (2×)\nMax Mustermann",
+		},
+		{
+			name:     "same count keeps the order",
+			keys:     []string{"Max Mustermann", "Erika Musterfrau"},
+			wantText: "Außerdem geändert:\nMax Mustermann\nErika Musterfrau",
+		},
+		{
+			name:       "too many articles",
+			keys:       many,
+			wantSuffix: "\n… und 19 weitere",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var digest []Post
+			for _, key := range tt.keys {
+				digest = append(digest, Post{ThreadKey: key})
+			}
+
+			post := digestPost(digest)
+			if tt.wantText != "" && post.Text != tt.wantText {
+				t.Errorf("got text %q, want %q", post.Text, tt.wantText)
+			}
+			if !strings.HasSuffix(post.Text, tt.wantSuffix) {
+				t.Errorf("got text %q, want it to end with %q", post.Text, tt.wantSuffix)
+			}
+			if n := len([]rune(post.Text)); n > 280 {
+				t.Errorf("text has %d characters, that's too long", n)
+			}
+			if post.ThreadKey != "rate_limit_digest" {
+				t.Errorf("got thread key %q", post.ThreadKey)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSerializesThreads(t *testing.T) {
+	publisher := &postRecorder{block: make(chan struct{}), started: make(chan struct{})}
+	r := &RateLimiter{
+		Publisher: publisher,
+		Overflow:  LimitQueue,
+		queue:     []Post{{ThreadKey: "Max Mustermann", Text: "Erste Änderung"}},
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		r.drain(context.Background())
+	}()
+	<-publisher.started
+
+	// The queue is empty now, but the queued post is still being published
+	published := make(chan error)
+	go func() {
+		_, err := r.Publish(context.Background(), Post{ThreadKey: "Max Mustermann", Text: "Zweite Änderung"})
+		published <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	publisher.mu.Lock()
+	calls := publisher.calls
+	publisher.mu.Unlock()
+	if calls != 1 {
+		t.Errorf("the second post was published while the first one was still being published")
+	}
+
+	close(publisher.block)
+	<-drained
+	if err := <-published; err != nil {
+		t.Fatalf("publishing failed: %v", err)
+	}
+
+	if got, want := publisher.published(), []string{"Erste Änderung", "Zweite Änderung"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got posts %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xarantolus/poliwiki/screenshot"
+)
+
+// ScreenshotRenderer takes a screenshot of the diff with a headless browser
+type ScreenshotRenderer struct{}
+
+func (ScreenshotRenderer) Render(ctx context.Context, item *Item) (png []byte, err error) {
+	start := time.Now()
+	png, err = screenshot.Take(item.DiffURL)
+	screenshotDuration.Observe(time.Since(start).Seconds())
+
+	if errors.Is(err, screenshot.ErrNotInteresting) {
+		return nil, skipf(ReasonNotInteresting, "seems like no interesting change was made to %s", item.DiffURL)
+	}
+	if err != nil {
+		screenshotFailures.Inc()
+	}
+
+	return
+}
@@ -0,0 +1,37 @@
+package pipeline
+
+import "fmt"
+
+// Reasons for skipping an event
+const (
+	ReasonObserved       = "observed"
+	ReasonNoName         = "no_name"
+	ReasonEventType      = "event_type"
+	ReasonNoDiffURL      = "no_diff_url"
+	ReasonSmallEdit      = "small_edit"
+	ReasonNotInteresting = "not_interesting"
+	ReasonTalkLimit      = "talk_limit"
+	ReasonPaused         = "paused"
+	ReasonMuted          = "muted"
+	ReasonRateLimited    = "rate_limited"
+)
+
+// Skip is returned by stages if an item should not be posted
+type Skip struct {
+	// Reason is one of the Reason constants, it can be used for metrics
+	Reason string
+
+	// Detail is a human-readable explanation
+	Detail string
+}
+
+func (s *Skip) Error() string {
+	return s.Detail
+}
+
+func skipf(reason, format string, args ...interface{}) *Skip {
+	return &Skip{
+		Reason: reason,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
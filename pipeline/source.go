@@ -0,0 +1,17 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// StreamSource streams events from wikipedia. Only events for which Accept returns true are returned
+type StreamSource struct {
+	Streamer wikipedia.Streamer
+	Accept   func(e *wikipedia.Event) bool
+}
+
+func (s *StreamSource) Events(ctx context.Context) <-chan wikipedia.Event {
+	return s.Streamer.Stream(ctx, s.Accept)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math"
 	"strconv"
 	"time"
@@ -14,6 +13,7 @@ import (
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
+	"github.com/xarantolus/poliwiki/logging"
 )
 
 var ErrNotInteresting = errors.New("this change is not interesting")
@@ -40,6 +40,38 @@ func Take(webpage string) (pngData []byte, err error) {
 	return
 }
 
+// HTML renders the given HTML document and returns a screenshot of the first element that matches sel
+func HTML(html, sel string) (pngData []byte, err error) {
+	ctx, c := context.WithTimeout(context.Background(), time.Minute)
+	defer c()
+
+	ctx, cc := chromedp.NewExecAllocator(ctx, chromedp.Headless)
+	defer cc()
+	ctx, ccc := chromedp.NewContext(ctx)
+	defer ccc()
+
+	err = chromedp.Run(ctx,
+		chromedp.Navigate("about:blank"),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			tree, err := page.GetFrameTree().Do(ctx)
+			if err != nil {
+				return err
+			}
+			return page.SetDocumentContent(tree.Frame.ID, html).Do(ctx)
+		}),
+		chromedp.Screenshot(sel, &pngData, chromedp.ByQuery),
+	)
+	if err != nil {
+		return
+	}
+
+	if len(pngData) == 0 {
+		err = fmt.Errorf("couldn't take screenshot, no error but no data received")
+	}
+
+	return
+}
+
 // This snippet counts the number of "interesting" changes on a wiki diff page, e.g.
 // it filters out very small changes and changes to metadata (e.g. link lists)
 // TODO: Maybe filter better; only check/count diff-addedline and deletedline if there's no diffchange-inline within it?
@@ -77,7 +109,7 @@ func elementScreenshot(urlstr, sel string, res *[]byte) chromedp.Tasks {
 		chromedp.ActionFunc(func(ctx context.Context) (err error) {
 			c, err := strconv.Atoi(string(interestingCount))
 			if err != nil {
-				log.Printf("[Screenshot] error: invalid number format while parsing interestingCount (%q): %s\n", interestingCount, err.Error())
+				logging.Warn("invalid number format while parsing interestingCount", "component", "screenshot", "value", string(interestingCount), "err", err)
 			}
 
 			if c == 0 {
@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Handler answers queries, the parameters are described at ParseQuery. The rows are returned as JSON,
+// or as CSV with "format=csv". Editors are only shown and accepted as pseudonyms, e.g.
+//
+//	GET /stats?group=week,article&party=SPD&since=30d
+//	GET /stats?group=user&party=CDU&limit=10&format=csv
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := r.URL.Query()
+
+		q, err := ParseQuery(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := s.Query(q)
+		if errors.Is(err, ErrNoUsers) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("running query failed", "query", r.URL.RawQuery, "err", err)
+			http.Error(w, "running query failed", http.StatusInternalServerError)
+			return
+		}
+
+		switch params.Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(rows)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = WriteCSV(w, q, rows)
+		default:
+			http.Error(w, "format must be json or csv", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Warn("writing query result failed", "err", err)
+		}
+	})
+}
+
+// header returns the column names for the rows of q
+func header(q Query) []string {
+	return append(append([]string{}, q.GroupBy...), "edits", "bytes")
+}
+
+// WriteCSV writes the rows with a header line
+func WriteCSV(w io.Writer, q Query, rows []Row) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(header(q))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		err = cw.Write(append(append([]string{}, r.Group...), strconv.Itoa(r.Edits), strconv.Itoa(r.Bytes)))
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the rows as a table that can be read in a terminal
+func WriteTable(w io.Writer, q Query, rows []Row) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(header(q), "\t"))
+	for _, r := range rows {
+		var cols = append(append([]string{}, r.Group...), strconv.Itoa(r.Edits), strconv.Itoa(r.Bytes))
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+
+	return tw.Flush()
+}
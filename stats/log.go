@@ -0,0 +1,5 @@
+package stats
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "stats")
@@ -0,0 +1,231 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys records can be grouped by
+const (
+	GroupDay     = "day"
+	GroupWeek    = "week"
+	GroupMonth   = "month"
+	GroupArticle = "article"
+	GroupParty   = "party"
+	GroupUser    = "user"
+	GroupType    = "type"
+	GroupOutcome = "outcome"
+	GroupKind    = "kind"
+)
+
+// Query selects records and groups them. Empty fields match everything
+type Query struct {
+	Since, Until time.Time
+
+	Article string
+	Party   string
+	User    string
+	Kind    string
+	Type    string
+	Outcome string
+
+	// GroupBy are the keys the rows are grouped by, e.g. GroupWeek and GroupArticle for edits per article per week
+	GroupBy []string
+
+	// Limit is the maximum number of rows, 0 means no limit
+	Limit int
+}
+
+// Row is the result for one group
+type Row struct {
+	Group []string `json:"group"`
+
+	Edits int `json:"edits"`
+	Bytes int `json:"bytes"`
+}
+
+// ParseQuery parses a query from URL parameters, e.g. "group=week,article&party=SPD&since=30d".
+// since and until can either be a date like "2021-08-01" or a duration before now like "30d" or "12h"
+func ParseQuery(v url.Values) (q Query, err error) {
+	q.Article = v.Get("article")
+	q.Party = v.Get("party")
+	q.User = v.Get("user")
+	q.Kind = v.Get("kind")
+	q.Type = v.Get("type")
+	q.Outcome = v.Get("outcome")
+
+	if s := v.Get("since"); s != "" {
+		q.Since, err = parseTime(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		q.Until, err = parseTime(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+
+	if s := v.Get("group"); s != "" {
+		for _, g := range strings.Split(s, ",") {
+			g = strings.TrimSpace(g)
+			if _, ok := groupKeys[g]; !ok {
+				return q, fmt.Errorf("cannot group by %q", g)
+			}
+			q.GroupBy = append(q.GroupBy, g)
+		}
+	}
+
+	if s := v.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+
+	return q, nil
+}
+
+func parseTime(s string) (t time.Time, err error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// groupKeys returns the value of a record for every key it can be grouped by
+var groupKeys = map[string]func(r Record) string{
+	GroupDay: func(r Record) string {
+		return r.Time.Local().Format("2006-01-02")
+	},
+	GroupWeek: func(r Record) string {
+		y, w := r.Time.Local().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	},
+	GroupMonth: func(r Record) string {
+		return r.Time.Local().Format("2006-01")
+	},
+	GroupArticle: func(r Record) string { return r.Title },
+	GroupParty:   func(r Record) string { return r.Party },
+	GroupUser:    func(r Record) string { return r.User },
+	GroupType:    func(r Record) string { return r.Type },
+	GroupOutcome: func(r Record) string { return r.Outcome },
+	GroupKind:    func(r Record) string { return r.Kind },
+}
+
+var pseudonymRegex = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// IsPseudonym returns whether s looks like a pseudonym returned by Store.Pseudonym
+func IsPseudonym(s string) bool {
+	return pseudonymRegex.MatchString(s)
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeKey(key string) bool {
+	return key == GroupDay || key == GroupWeek || key == GroupMonth
+}
+
+// Matches returns whether the record is selected by the query
+func (q Query) Matches(r Record) bool {
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+
+	return match(q.Article, r.Title) &&
+		match(q.Party, r.Party) &&
+		match(q.User, r.User) &&
+		match(q.Kind, r.Kind) &&
+		match(q.Type, r.Type) &&
+		match(q.Outcome, r.Outcome)
+}
+
+func match(want, value string) bool {
+	return want == "" || strings.EqualFold(want, value)
+}
+
+// ErrNoUsers is returned for queries about editors if there's no user key
+var ErrNoUsers = errors.New("editors are not stored, as there's no user key")
+
+// Query runs the query on all records in the store. Query.User must be a pseudonym, see Store.Pseudonym.
+// Only the operator should be able to turn names into pseudonyms, otherwise anyone could find out whether someone edited an article.
+// Rows are sorted by time if the first group key is a time, otherwise the groups with most edits come first
+func (s *Store) Query(q Query) (rows []Row, err error) {
+	if (q.User != "" || containsKey(q.GroupBy, GroupUser)) && len(s.userKey) == 0 {
+		return nil, ErrNoUsers
+	}
+
+	var groups = make(map[string]*Row)
+
+	err = s.Each(q.Since, func(r Record) {
+		if !q.Matches(r) {
+			return
+		}
+
+		var group = make([]string, len(q.GroupBy))
+		for i, key := range q.GroupBy {
+			group[i] = groupKeys[key](r)
+		}
+
+		// \x00 doesn't appear in titles or user names
+		var id = strings.Join(group, "\x00")
+		row, ok := groups[id]
+		if !ok {
+			row = &Row{Group: group}
+			groups[id] = row
+		}
+		row.Edits++
+		row.Bytes += r.Bytes()
+	})
+	if err != nil {
+		return
+	}
+
+	rows = make([]Row, 0, len(groups))
+	for _, r := range groups {
+		rows = append(rows, *r)
+	}
+
+	var byTime = len(q.GroupBy) > 0 && isTimeKey(q.GroupBy[0])
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if byTime && a.Group[0] != b.Group[0] {
+			return a.Group[0] < b.Group[0]
+		}
+		if a.Edits != b.Edits {
+			return a.Edits > b.Edits
+		}
+		return strings.Join(a.Group, "\x00") < strings.Join(b.Group, "\x00")
+	})
+
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+
+	return
+}
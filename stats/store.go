@@ -0,0 +1,223 @@
+// Package stats keeps a history of all matched events and what happened to them, so it can be queried later,
+// e.g. for edits per politician per week or the most active editors of articles about members of a party.
+// Records are appended to a file with one JSON object per line
+package stats
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/pipeline"
+	"github.com/xarantolus/poliwiki/wikidata"
+)
+
+// Kinds of subjects
+const (
+	KindPolitician   = "politician"
+	KindOrganization = "organization"
+)
+
+// Record is a matched event and the decision about it
+type Record struct {
+	Time time.Time `json:"time"`
+
+	Wiki     string `json:"wiki"`
+	Type     string `json:"type"`
+	Revision int    `json:"revision,omitempty"`
+	LogType  string `json:"log_type,omitempty"`
+
+	// Page is the page that was changed, Title the article it is about. They only differ for talk pages
+	Page     string `json:"page"`
+	Title    string `json:"title"`
+	TalkPage bool   `json:"talk_page,omitempty"`
+
+	// Kind is KindPolitician or KindOrganization
+	Kind string `json:"kind"`
+	// QID is the WikiData ID of politicians
+	QID  string `json:"qid,omitempty"`
+	Name string `json:"name"`
+	// Party is the short name of the current party of a politician, or of the party itself
+	Party string `json:"party,omitempty"`
+
+	// User is a pseudonym of the editor, see Open. Names and IP addresses of editors are never stored
+	User string `json:"user,omitempty"`
+	Bot  bool   `json:"bot,omitempty"`
+
+	// Added is the number of characters that were added, negative if more were removed
+	Added int `json:"added"`
+
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+
+	// Posts are the URLs of posts about the event
+	Posts []string `json:"posts,omitempty"`
+}
+
+// NewRecord returns the record of a decision. User is not set, as it must be a pseudonym
+func NewRecord(d pipeline.Decision) Record {
+	var (
+		e = d.Item.Event
+		s = d.Item.Subject
+	)
+
+	r := Record{
+		Time:     e.Time().UTC(),
+		Wiki:     e.Wiki,
+		Type:     e.Type,
+		Revision: e.Revision.New,
+		LogType:  e.LogType,
+		Page:     d.Item.Page,
+		Title:    s.Title,
+		TalkPage: e.IsTalkPage(),
+		Bot:      e.Bot,
+		Added:    e.Length.New - e.Length.Old,
+		Outcome:  d.Outcome,
+		Reason:   d.Reason,
+	}
+
+	switch {
+	case s.Politician != nil:
+		r.Kind = KindPolitician
+		r.QID = s.Politician.ID
+		r.Name = s.Politician.Name
+		r.Party = s.Politician.PartyShortname()
+	case s.Organization != nil:
+		r.Kind = KindOrganization
+		r.Name = s.Organization.Name
+		if s.Organization.Kind == wikidata.KindParty {
+			r.Party = s.Organization.ShortName
+		}
+	}
+	if r.Name == "" {
+		r.Name = s.Title
+	}
+
+	for _, p := range d.Posts {
+		r.Posts = append(r.Posts, p.URL)
+	}
+
+	return r
+}
+
+// Bytes is the number of characters that were changed
+func (r Record) Bytes() int {
+	if r.Added < 0 {
+		return -r.Added
+	}
+	return r.Added
+}
+
+// Store appends records to a file and reads them for queries. It's safe for concurrent use
+type Store struct {
+	file    string
+	userKey []byte
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the store in the given file, it's created if it doesn't exist.
+// Editors are stored as keyed hash of their name or IP address with userKey, so their edits can still be
+// grouped without anyone being able to find out who they are. If userKey is empty, editors are not stored at all
+func Open(file, userKey string) (s *Store, err error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+
+	return &Store{
+		file:    file,
+		userKey: []byte(userKey),
+		f:       f,
+	}, nil
+}
+
+// Pseudonym returns the pseudonym of the user as it's stored, or an empty string if editors are not stored
+func (s *Store) Pseudonym(user string) string {
+	if len(s.userKey) == 0 || user == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, s.userKey)
+	mac.Write([]byte(user))
+
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Record appends the decision to the store. It can be used as pipeline.Pipeline.OnDecision
+func (s *Store) Record(d pipeline.Decision) {
+	r := NewRecord(d)
+	r.User = s.Pseudonym(d.Item.Event.User)
+
+	err := s.Add(r)
+	if err != nil {
+		d.Item.Log.Error("writing record to stats store failed", "err", err)
+	}
+}
+
+// Add appends the record to the store
+func (s *Store) Add(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+// Each calls fn for every record that happened at or after since, in the order they were added.
+// All records are read from the file, so it gets slower the more records there are
+func (s *Store) Each(since time.Time, fn func(r Record)) error {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var r Record
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			// A line could be cut off if the bot was killed while writing it
+			logger.Warn("skipping invalid record", "file", s.file, "err", err)
+			continue
+		}
+
+		if r.Time.Before(since) {
+			continue
+		}
+
+		fn(r)
+	}
+
+	return scanner.Err()
+}
+
+// Close closes the file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return errors.New("store is already closed")
+	}
+
+	err := s.f.Close()
+	s.f = nil
+
+	return err
+}
@@ -0,0 +1,127 @@
+package wikidata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Select all german organizations of one class (see orgClasses). The items are paged on their own, see Client.QueryItemPages
+const orgitemquery = `SELECT DISTINCT ?item WHERE {
+  ?item wdt:P31/wdt:P279* ` + orgClassPlaceholder + `;
+    wdt:P17 wd:Q183.
+}
+ORDER BY ?item`
+
+// Select the organizations selected by orgitemquery that have an article on the german wikipedia.
+// Like the politicians query, you can edit this using https://query.wikidata.org/
+const orgquery = `SELECT DISTINCT ?item ?page_title ?article_url ?name ?shortName ?hashtag ?twitterName WHERE {
+  ` + ItemsPlaceholder + `
+  ?item rdfs:label ?name.
+  FILTER(LANG(?name) = "de")
+  ?article_url schema:about ?item;
+    schema:isPartOf <https://de.wikipedia.org/>;
+    schema:name ?page_title.
+  OPTIONAL {
+    ?item wdt:P1813 ?shortName.
+    FILTER(LANG(?shortName) = "de")
+  }
+  OPTIONAL {
+    ?item wdt:P2572 ?hashtag.
+  }
+  OPTIONAL {
+    ?item wdt:P2002 ?twitterName.
+  }
+}`
+
+const orgClassPlaceholder = "{{class}}"
+
+// orgClasses are the WikiData classes of the organization kinds. If an item is an instance of more than one,
+// the kind that comes first wins, e.g. a party that is also a parliamentary group is a party
+var orgClasses = []struct {
+	Kind  OrganizationKind
+	Class string
+}{
+	{KindParty, "wd:Q7278"},
+	{KindParliament, "wd:Q35749"},
+	{KindMinistry, "wd:Q192350"},
+}
+
+// Organizations returns a store that contains all german parties, parliaments and ministries that have a german wikipedia article.
+// Every kind is fetched with its own paged query, as one query for all of them easily runs into timeouts
+func Organizations() (store OrganizationStore, err error) {
+	store = OrganizationStore{
+		organizations: make(map[string]Organization),
+	}
+
+	for _, c := range orgClasses {
+		var itemQuery = strings.Replace(orgitemquery, orgClassPlaceholder, c.Class, 1)
+
+		err = defaultClient.QueryItemPages(context.Background(), itemQuery, orgquery, func(rows []Row) (err error) {
+			var page []orgInfo
+			err = DecodeRows(rows, &page)
+			if err != nil {
+				return
+			}
+
+			for _, result := range page {
+				store.add(result.toOrg(c.Kind))
+			}
+
+			return
+		})
+		if err != nil {
+			return store, fmt.Errorf("fetching %s organizations: %w", c.Kind, err)
+		}
+	}
+
+	return
+}
+
+// add adds the organization to the store. Items can be in there multiple times, e.g. if they have more than one
+// short name or are an instance of more than one of the classes. Kinds that were added first are kept,
+// but fields that were empty so far are filled in
+func (s *OrganizationStore) add(o Organization) {
+	existing, ok := s.organizations[o.WikiPageTitle]
+	if !ok {
+		s.organizations[o.WikiPageTitle] = o
+		return
+	}
+
+	if existing.Kind != o.Kind {
+		return
+	}
+
+	if existing.ShortName == "" {
+		existing.ShortName = o.ShortName
+	}
+	if existing.Hashtag == "" {
+		existing.Hashtag = o.Hashtag
+	}
+	if existing.TwitterName == "" {
+		existing.TwitterName = o.TwitterName
+	}
+
+	s.organizations[o.WikiPageTitle] = existing
+}
+
+type orgInfo struct {
+	PageTitle   string `sparql:"page_title"`
+	ArticleURL  string `sparql:"article_url"`
+	Name        string `sparql:"name"`
+	ShortName   string `sparql:"shortName"`
+	Hashtag     string `sparql:"hashtag"`
+	TwitterName string `sparql:"twitterName"`
+}
+
+func (i *orgInfo) toOrg(kind OrganizationKind) Organization {
+	return Organization{
+		Kind:           kind,
+		Name:           i.Name,
+		ShortName:      i.ShortName,
+		Hashtag:        strings.TrimPrefix(i.Hashtag, "#"),
+		TwitterName:    i.TwitterName,
+		WikiPageTitle:  i.PageTitle,
+		WikiArticleURL: i.ArticleURL,
+	}
+}
@@ -1,24 +1,28 @@
 package wikidata
 
 import (
-	"encoding/json"
-	"net/http"
-	"net/url"
+	"context"
+	"path"
 	"strings"
 	"time"
 )
 
-// Select all politicians, aka people with a abgeordnetenwatch.de id (P5355)
-// You can edit this using https://query.wikidata.org/
-const (
-	poliquery = `SELECT DISTINCT ?item ?page_title ?article_url ?name ?first_name ?last_name ?partyHashtag ?partyTwittername ?partyShortname WHERE {
-  ?item wdt:P5355 ?value;
-    wdt:P1559 ?name.
-  ?article schema:about ?item;
+// Select all politicians, aka people with a abgeordnetenwatch.de id (P5355).
+// The items are paged on their own, see Client.QueryItemPages
+const poliitemquery = `SELECT DISTINCT ?item WHERE {
+  ?item wdt:P5355 ?value.
+}
+ORDER BY ?item`
+
+// Select the names, article and party memberships of the politicians selected by poliitemquery.
+// Every party membership (P102) is returned as its own row, together with the start (P580) and end (P582) qualifiers.
+// You can edit this using https://query.wikidata.org/ (replace the items placeholder with the poliitemquery subquery first)
+const poliquery = `SELECT DISTINCT ?item ?page_title ?article_url ?name ?first_name ?last_name ?party ?partyName ?partyStart ?partyEnd ?partyHashtag ?partyTwittername ?partyShortname WHERE {
+  ` + ItemsPlaceholder + `
+  ?item wdt:P1559 ?name.
+  ?article_url schema:about ?item;
     schema:isPartOf <https://de.wikipedia.org/>;
     schema:name ?page_title.
-  ?article_url schema:about ?item;
-    schema:isPartOf <https://de.wikipedia.org/>.
   OPTIONAL {
     ?item wdt:P735 ?fval.
     ?fval wdt:P1705 ?first_name.
@@ -28,135 +32,136 @@ const (
     ?lval wdt:P1705 ?last_name.
   }
   OPTIONAL {
-    ?item wdt:P102 ?pval.
-    ?pval wdt:P2572 ?partyHashtag.
+    ?item p:P102 ?partyStatement.
+    ?partyStatement ps:P102 ?party.
+    OPTIONAL { ?partyStatement pq:P580 ?partyStart. }
+    OPTIONAL { ?partyStatement pq:P582 ?partyEnd. }
+    OPTIONAL {
+      ?party rdfs:label ?partyName.
+      FILTER(LANG(?partyName) = "de")
+    }
+    OPTIONAL { ?party wdt:P2572 ?partyHashtag. }
+    OPTIONAL { ?party wdt:P2002 ?partyTwittername. }
+    OPTIONAL {
+      ?party wdt:P1813 ?partyShortname.
+      FILTER(LANG(?partyShortname) = "de")
+    }
   }
-  OPTIONAL {
-    ?item wdt:P102 ?pval.
-    ?pval wdt:P2002 ?partyTwittername.
-  }
-  OPTIONAL {
-    ?item wdt:P102 ?pval.
-    ?pval wdt:P1813 ?partyShortname.
-  }
-  SERVICE wikibase:label { bd:serviceParam wikibase:language "de". }
 }`
 
-	queryURLPrefix = "https://query.wikidata.org/sparql?format=json&query="
-)
+// Politicians returns a politicians store that contains all politicians that have an abgeordnetenwatch.de ID assigned to them on WikiData
+func Politicians() (store PoliticianStore, err error) {
+	store = PoliticianStore{
+		politicians: make(map[string]Politician),
+		titles:      make(map[string]string),
+	}
 
-var c = http.Client{
-	Timeout: 30 * time.Second,
+	err = store.fetch(poliitemquery)
+
+	return
 }
 
-// Politicians returns a politicians store that contains all politicians that have an abgeordnetenwatch.de ID assigned to them on WikiData
-func Politicians() (store PoliticianStore, err error) {
-	var queryURL = queryURLPrefix + url.QueryEscape(poliquery)
+// Select the politician whose german wikipedia article has the title given by the title placeholder
+const polititlequery = `SELECT DISTINCT ?item WHERE {
+  ?article schema:about ?item;
+    schema:isPartOf <https://de.wikipedia.org/>;
+    schema:name ` + titlePlaceholder + `.
+  ?item wdt:P5355 ?value.
+}
+ORDER BY ?item`
 
-	resp, err := c.Get(queryURL)
+const titlePlaceholder = "{{title}}"
+
+var sparqlStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// PoliticianByTitle fetches the politician whose german wikipedia article has the given title, e.g. because the article
+// was created after the store was loaded. ok is false if there's no such politician on WikiData (yet)
+func PoliticianByTitle(title string) (p Politician, ok bool, err error) {
+	store := NewPoliticianStore()
+
+	err = store.fetch(strings.Replace(polititlequery, titlePlaceholder, `"`+sparqlStringEscaper.Replace(title)+`"@de`, 1))
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
 
-	var data response
+	p, ok = store.Get(title)
+	return
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
+// fetch adds the politicians selected by itemQuery to the store, see Client.QueryItemPages
+func (s *PoliticianStore) fetch(itemQuery string) (err error) {
+	err = defaultClient.QueryItemPages(context.Background(), itemQuery, poliquery, func(rows []Row) (err error) {
+		var page []info
+		err = DecodeRows(rows, &page)
+		if err != nil {
+			return
+		}
+
+		for _, result := range page {
+			s.add(result)
+		}
+
+		return
+	})
 	if err != nil {
 		return
 	}
 
-	store = PoliticianStore{
-		politicians: make(map[string]Politician, len(data.Results.Bindings)),
-	}
+	return s.addPositions(itemQuery)
+}
 
-	for _, result := range data.Results.Bindings {
-		p := result.toPoli()
-
-		currentPoli, ok := store.politicians[p.WikiPageTitle]
-		if ok {
-			// Some pages are in there twice because of translations of certain fields.
-			// Some politicians have two names, which results in two rows in the data.
-			// Some are also in there twice because they changed party. It seems like keeping the first record of the data
-			// is the correct way to go, but this might not be true for every politicians.
-			// This should be fixed in the SPARQL query rather than here, but I don't really know how
-
-			// If we have a first name that is more accurate, we use that
-			// E.g. Andreas Scheuer is first seen as Franz Scheuer, but this fixes it
-			if p.FirstName != "" && strings.HasPrefix(currentPoli.WikiPageTitle, p.FirstName) {
-				goto breakout
-			}
-			continue
+// add adds the politician and party membership of a result row to the store
+func (s *PoliticianStore) add(result info) {
+	p := result.toPoli()
+	party, hasParty := result.toParty()
+
+	currentPoli, ok := s.politicians[p.WikiPageTitle]
+	if ok {
+		// Some pages are in there multiple times because of translations of certain fields,
+		// because some politicians have two names or because they have been a member of more than one party.
+		// We keep the names of the first record, but collect all party memberships
+
+		// If we have a first name that is more accurate, we use that
+		// E.g. Andreas Scheuer is first seen as Franz Scheuer, but this fixes it
+		if p.FirstName != "" && strings.HasPrefix(currentPoli.WikiPageTitle, p.FirstName) {
+			currentPoli.Name, currentPoli.FirstName, currentPoli.LastName = p.Name, p.FirstName, p.LastName
 		}
-	breakout:
-		store.politicians[p.WikiPageTitle] = p
-	}
 
-	return
-}
+		p = currentPoli
+	}
 
-type response struct {
-	Head struct {
-		Vars []string `json:"vars"`
-	} `json:"head"`
-	Results result `json:"results"`
-}
+	if hasParty {
+		p.addParty(party)
+	}
 
-type result struct {
-	Bindings []info `json:"bindings"`
+	s.politicians[p.WikiPageTitle] = p
+	s.titles[p.ID] = p.WikiPageTitle
 }
 
 type info struct {
-	Item struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"item"`
-	Name struct {
-		XMLLang string `json:"xml:lang"`
-		Type    string `json:"type"`
-		Value   string `json:"value"`
-	} `json:"name"`
-	PageTitle struct {
-		XMLLang string `json:"xml:lang"`
-		Type    string `json:"type"`
-		Value   string `json:"value"`
-	} `json:"page_title"`
-	ArticleURL struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"article_url"`
-	FirstName struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"first_name"`
-	LastName struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"last_name"`
-	PartyHashtag struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"partyHashtag"`
-	PartyTwittername struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"partyTwittername"`
-	PartyShortname struct {
-		Type  string `json:"type"`
-		Value string `json:"value"`
-	} `json:"partyShortname"`
+	Item             string    `sparql:"item,id"`
+	Name             string    `sparql:"name"`
+	PageTitle        string    `sparql:"page_title"`
+	ArticleURL       string    `sparql:"article_url"`
+	FirstName        string    `sparql:"first_name"`
+	LastName         string    `sparql:"last_name"`
+	Party            string    `sparql:"party,id"`
+	PartyName        string    `sparql:"partyName"`
+	PartyStart       time.Time `sparql:"partyStart"`
+	PartyEnd         time.Time `sparql:"partyEnd"`
+	PartyHashtag     string    `sparql:"partyHashtag"`
+	PartyTwittername string    `sparql:"partyTwittername"`
+	PartyShortname   string    `sparql:"partyShortname"`
 }
 
 func (i *info) toPoli() Politician {
 	var p = Politician{
-		Name:             i.Name.Value,
-		WikiPageTitle:    i.PageTitle.Value,
-		WikiArticleURL:   i.ArticleURL.Value,
-		FirstName:        i.FirstName.Value,
-		LastName:         i.LastName.Value,
-		partyHashtag:     i.PartyHashtag.Value,
-		partyShortname:   i.PartyShortname.Value,
-		partyTwittername: i.PartyTwittername.Value,
+		ID:             i.Item,
+		Name:           i.Name,
+		WikiPageTitle:  i.PageTitle,
+		WikiArticleURL: i.ArticleURL,
+		FirstName:      i.FirstName,
+		LastName:       i.LastName,
 	}
 
 	// Sometimes the first name/last name is missing, so we try to infer it from other information
@@ -174,3 +179,35 @@ func (i *info) toPoli() Politician {
 
 	return p
 }
+
+// toParty returns the party membership described by this row, if there is one
+func (i *info) toParty() (m PartyMembership, ok bool) {
+	if i.Party == "" {
+		return
+	}
+
+	return PartyMembership{
+		ID:          i.Party,
+		Name:        i.PartyName,
+		ShortName:   i.PartyShortname,
+		Hashtag:     strings.TrimPrefix(i.PartyHashtag, "#"),
+		TwitterName: i.PartyTwittername,
+		Start:       i.PartyStart,
+		End:         i.PartyEnd,
+	}, true
+}
+
+// entityID returns the ID of an entity from its URI, e.g. "http://www.wikidata.org/entity/Q567" becomes "Q567"
+func entityID(uri string) string {
+	return path.Base(uri)
+}
+
+// parseTime parses a date returned by the query service. Dates that are unknown or that
+// cannot be represented (e.g. BC dates) are returned as the zero time
+func parseTime(s string) (t time.Time) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package wikidata
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Select the image and all positions (P39) politicians currently hold, together with the parliamentary group (P4100)
+// and constituency (P768) qualifiers. The level of a position is derived from its jurisdiction (P1001).
+// This is a separate query because adding it to the politicians query would multiply its rows
+const positionquery = `SELECT DISTINCT ?item ?image ?positionStatement ?position ?positionName ?positionShortName ?level ?positionStart ?positionEnd ?groupName ?constituencyName ?stateName WHERE {
+  ` + ItemsPlaceholder + `
+  ?article schema:about ?item;
+    schema:isPartOf <https://de.wikipedia.org/>.
+  OPTIONAL { ?item wdt:P18 ?image. }
+  OPTIONAL {
+    ?item p:P39 ?positionStatement.
+    ?positionStatement ps:P39 ?position.
+    FILTER NOT EXISTS { ?positionStatement wikibase:rank wikibase:DeprecatedRank. }
+    FILTER NOT EXISTS {
+      ?positionStatement pq:P582 ?ended.
+      FILTER(?ended < NOW())
+    }
+    OPTIONAL { ?positionStatement pq:P580 ?positionStart. }
+    OPTIONAL { ?positionStatement pq:P582 ?positionEnd. }
+    OPTIONAL {
+      ?position rdfs:label ?positionName.
+      FILTER(LANG(?positionName) = "de")
+    }
+    OPTIONAL {
+      ?position wdt:P1813 ?positionShortName.
+      FILTER(LANG(?positionShortName) = "de")
+    }
+    OPTIONAL {
+      ?position wdt:P1001 ?jurisdiction.
+      BIND(IF(?jurisdiction = wd:Q183, "federal", IF(?jurisdiction = wd:Q458, "european", IF(EXISTS { ?jurisdiction wdt:P31 wd:Q1221156. }, "state", "other"))) AS ?level)
+    }
+    OPTIONAL {
+      ?positionStatement pq:P4100 ?group.
+      ?group rdfs:label ?groupName.
+      FILTER(LANG(?groupName) = "de")
+    }
+    OPTIONAL {
+      ?positionStatement pq:P768 ?constituency.
+      ?constituency rdfs:label ?constituencyName.
+      FILTER(LANG(?constituencyName) = "de")
+    }
+    OPTIONAL {
+      { ?position wdt:P1001 ?state. } UNION { ?positionStatement pq:P768/wdt:P131* ?state. }
+      ?state wdt:P31 wd:Q1221156;
+        rdfs:label ?stateName.
+      FILTER(LANG(?stateName) = "de")
+    }
+  }
+}`
+
+// addPositions fetches the positions and images of the politicians selected by itemQuery and adds them to the politicians in the store
+func (s *PoliticianStore) addPositions(itemQuery string) (err error) {
+	return defaultClient.QueryItemPages(context.Background(), itemQuery, positionquery, func(rows []Row) (err error) {
+		var results []positionInfo
+		err = DecodeRows(rows, &results)
+		if err != nil {
+			return
+		}
+
+		for _, result := range results {
+			title, ok := s.titles[result.Item]
+			if !ok {
+				continue
+			}
+
+			p := s.politicians[title]
+
+			if p.Image == "" && result.Image != "" {
+				p.Image = commonsFileName(result.Image)
+			}
+
+			if result.Position != "" {
+				p.addPosition(result.toPosition())
+			}
+
+			s.politicians[title] = p
+		}
+
+		return
+	})
+}
+
+type positionInfo struct {
+	Item              string    `sparql:"item,id"`
+	Image             string    `sparql:"image"`
+	PositionStatement string    `sparql:"positionStatement"`
+	Position          string    `sparql:"position,id"`
+	PositionName      string    `sparql:"positionName"`
+	PositionShortName string    `sparql:"positionShortName"`
+	Level             string    `sparql:"level"`
+	PositionStart     time.Time `sparql:"positionStart"`
+	PositionEnd       time.Time `sparql:"positionEnd"`
+	GroupName         string    `sparql:"groupName"`
+	ConstituencyName  string    `sparql:"constituencyName"`
+	StateName         string    `sparql:"stateName"`
+}
+
+func (i *positionInfo) toPosition() Position {
+	return Position{
+		statement:          i.PositionStatement,
+		ID:                 i.Position,
+		Name:               i.PositionName,
+		ShortName:          i.PositionShortName,
+		Level:              Level(i.Level),
+		Start:              i.PositionStart,
+		End:                i.PositionEnd,
+		ParliamentaryGroup: i.GroupName,
+		Constituency:       i.ConstituencyName,
+		State:              i.StateName,
+	}
+}
+
+// commonsFileName returns the file name from a Special:FilePath URL returned for images,
+// e.g. "http://commons.wikimedia.org/wiki/Special:FilePath/Max%20Mustermann.jpg" becomes "Max Mustermann.jpg"
+func commonsFileName(u string) string {
+	name := path.Base(u)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
@@ -0,0 +1,262 @@
+package wikidata
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ByID returns the politician with the given WikiData ID, e.g. "Q567"
+func (s *PoliticianStore) ByID(id string) (p Politician, ok bool) {
+	title, ok := s.titles[id]
+	if !ok {
+		return
+	}
+	return s.Get(title)
+}
+
+// Search returns all politicians whose name or page title is similar to the given name.
+// Case, umlauts and punctuation are ignored and small typos are allowed. The best matches come first
+func (s *PoliticianStore) Search(name string) (result []Politician) {
+	var query = normalizeName(name)
+	if query == "" {
+		return nil
+	}
+
+	var scores = make(map[string]int)
+	for title, p := range s.politicians {
+		best := -1
+		for _, candidate := range []string{p.Name, p.WikiPageTitle, p.FirstName + " " + p.LastName} {
+			score, ok := matchScore(query, normalizeName(candidate))
+			if ok && (best < 0 || score < best) {
+				best = score
+			}
+		}
+
+		if best >= 0 {
+			scores[title] = best
+			result = append(result, p)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		si, sj := scores[result[i].WikiPageTitle], scores[result[j].WikiPageTitle]
+		if si != sj {
+			return si < sj
+		}
+		return result[i].WikiPageTitle < result[j].WikiPageTitle
+	})
+
+	return
+}
+
+// Search returns all organizations whose name, short name or page title is similar to the given name.
+// It works like PoliticianStore.Search
+func (s *OrganizationStore) Search(name string) (result []Organization) {
+	var query = normalizeName(name)
+	if query == "" {
+		return nil
+	}
+
+	var scores = make(map[string]int)
+	for title, o := range s.organizations {
+		best := -1
+		for _, candidate := range []string{o.Name, o.ShortName, o.WikiPageTitle} {
+			score, ok := matchScore(query, normalizeName(candidate))
+			if ok && (best < 0 || score < best) {
+				best = score
+			}
+		}
+
+		if best >= 0 {
+			scores[title] = best
+			result = append(result, o)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		si, sj := scores[result[i].WikiPageTitle], scores[result[j].WikiPageTitle]
+		if si != sj {
+			return si < sj
+		}
+		return result[i].WikiPageTitle < result[j].WikiPageTitle
+	})
+
+	return
+}
+
+// ByParty returns all politicians that are currently a member of the given party.
+// The party can be given by its WikiData ID, name, short name or hashtag
+func (s *PoliticianStore) ByParty(party string) (result []Politician) {
+	return s.Filter(func(p Politician) bool {
+		current, ok := p.CurrentParty()
+		return ok && matchesAny(party, current.ID, current.Name, current.ShortName, current.Hashtag)
+	})
+}
+
+// ByPosition returns all politicians that currently hold the given position.
+// The position can be given by its WikiData ID, name or short name, e.g. "MdB"
+func (s *PoliticianStore) ByPosition(position string) (result []Politician) {
+	return s.Filter(func(p Politician) bool {
+		for _, pos := range p.Positions {
+			if matchesAny(position, pos.ID, pos.Name, pos.ShortName) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Filter returns all politicians for which keep returns true, sorted by page title
+func (s *PoliticianStore) Filter(keep func(p Politician) bool) (result []Politician) {
+	s.Each(func(p Politician) bool {
+		if keep(p) {
+			result = append(result, p)
+		}
+		return true
+	})
+	return
+}
+
+// All returns all politicians, sorted by page title
+func (s *PoliticianStore) All() []Politician {
+	return s.Filter(func(Politician) bool { return true })
+}
+
+// Each calls f for every politician, sorted by page title. It stops when f returns false
+func (s *PoliticianStore) Each(f func(p Politician) bool) {
+	var titles = make([]string, 0, len(s.politicians))
+	for title := range s.politicians {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	for _, title := range titles {
+		if !f(s.politicians[title]) {
+			return
+		}
+	}
+}
+
+// WriteJSON writes all politicians as a JSON array to w
+func (s *PoliticianStore) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.All())
+}
+
+// WriteCSV writes all politicians to w, one row per politician.
+// Only the current party and the main position are included
+func (s *PoliticianStore) WriteCSV(w io.Writer) (err error) {
+	cw := csv.NewWriter(w)
+
+	err = cw.Write([]string{"id", "page_title", "name", "first_name", "last_name", "party", "position", "level", "parliamentary_group", "constituency", "state", "image", "article_url"})
+	if err != nil {
+		return
+	}
+
+	s.Each(func(p Politician) bool {
+		party, _ := p.CurrentParty()
+		pos, _ := p.MainPosition()
+
+		err = cw.Write([]string{
+			p.ID, p.WikiPageTitle, p.Name, p.FirstName, p.LastName,
+			party.Name, pos.Name, string(pos.Level),
+			p.ParliamentaryGroup(), p.Constituency(), p.State(),
+			p.Image, p.WikiArticleURL,
+		})
+		return err == nil
+	})
+	if err != nil {
+		return
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// matchesAny returns whether query is equal to any of the values, ignoring case
+func matchesAny(query string, values ...string) bool {
+	query = strings.TrimPrefix(strings.TrimSpace(query), "#")
+	for _, v := range values {
+		if v != "" && strings.EqualFold(query, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchScore returns how well the query matches the name, lower is better.
+// Both must already be normalized using normalizeName
+func matchScore(query, name string) (score int, ok bool) {
+	switch {
+	case name == "":
+		return 0, false
+	case query == name:
+		return 0, true
+	case strings.Contains(name, query):
+		return 1, true
+	}
+
+	// Allow about one typo per five characters
+	var maxDistance = len([]rune(query)) / 5
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	if d := levenshtein(query, name); d <= maxDistance {
+		return 1 + d, true
+	}
+
+	return 0, false
+}
+
+var umlautReplacer = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+
+// normalizeName lowercases the name, replaces umlauts and removes everything that isn't a letter or a digit
+func normalizeName(name string) string {
+	name = umlautReplacer.Replace(strings.ToLower(name))
+
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) (m int) {
+	m = values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return
+}
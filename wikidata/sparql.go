@@ -0,0 +1,369 @@
+package wikidata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is the SPARQL endpoint of the WikiData query service
+	DefaultEndpoint = "https://query.wikidata.org/sparql"
+
+	// DefaultUserAgent identifies the bot, see https://meta.wikimedia.org/wiki/User-Agent_policy
+	DefaultUserAgent = "poliwiki/1.0 (https://github.com/xarantolus/poliwiki)"
+
+	// ItemsPlaceholder is replaced by a VALUES clause that binds ?item to the items of the current page when using QueryItemPages
+	ItemsPlaceholder = "{{items}}"
+)
+
+// Client runs SPARQL queries. It retries requests that failed because of rate limits or server errors
+type Client struct {
+	// Endpoint is the URL queries are sent to
+	Endpoint string
+
+	UserAgent string
+
+	HTTPClient *http.Client
+
+	// UsePOST sends the query in a form body instead of the URL, which is needed for long queries
+	UsePOST bool
+
+	// MaxRetries is how often a failed request is retried before giving up
+	MaxRetries int
+
+	// PageSize is the LIMIT used by QueryPages and QueryItemPages
+	PageSize int
+}
+
+// NewClient returns a client for the WikiData query service
+func NewClient() *Client {
+	return &Client{
+		Endpoint:  DefaultEndpoint,
+		UserAgent: DefaultUserAgent,
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		UsePOST:    true,
+		MaxRetries: 5,
+		PageSize:   2000,
+	}
+}
+
+// defaultClient is used by the functions of this package that fetch data
+var defaultClient = NewClient()
+
+// Binding is a single value in a result row
+type Binding struct {
+	// Type is "uri", "literal" or "bnode"
+	Type string `json:"type"`
+
+	Value string `json:"value"`
+
+	XMLLang  string `json:"xml:lang"`
+	Datatype string `json:"datatype"`
+}
+
+// EntityID returns the ID of an entity binding, e.g. "http://www.wikidata.org/entity/Q567" becomes "Q567"
+func (b Binding) EntityID() string {
+	return entityID(b.Value)
+}
+
+// Time returns the value as time. It's zero if the value is unset or can't be represented
+func (b Binding) Time() time.Time {
+	return parseTime(b.Value)
+}
+
+// Row maps the variable names of a query to their values. Variables that are not bound are missing
+type Row map[string]Binding
+
+// Decode decodes the row into the struct pointed to by v. Fields are matched by their `sparql` tag,
+// e.g. `sparql:"page_title"`. Adding the ",id" option to a string field stores the entity ID instead of the URI.
+// Supported field types are string, int, int64, float64, bool, time.Time and Binding. Unbound variables are left as zero values
+func (r Row) Decode(v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decoding row: expected pointer to struct, but got %T", v)
+	}
+	rv = rv.Elem()
+
+	var rt = rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+
+		tag := field.Tag.Get("sparql")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		b, ok := r[name]
+		if !ok {
+			continue
+		}
+
+		err = setField(rv.Field(i), b, opts == "id")
+		if err != nil {
+			return fmt.Errorf("decoding variable %q into field %s: %w", name, field.Name, err)
+		}
+	}
+
+	return
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	bindingType = reflect.TypeOf(Binding{})
+)
+
+func setField(f reflect.Value, b Binding, asID bool) (err error) {
+	switch f.Type() {
+	case timeType:
+		f.Set(reflect.ValueOf(b.Time()))
+		return
+	case bindingType:
+		f.Set(reflect.ValueOf(b))
+		return
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		if asID {
+			f.SetString(b.EntityID())
+		} else {
+			f.SetString(b.Value)
+		}
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(b.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(b.Value, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(b.Value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+
+	return
+}
+
+// DecodeRows decodes all rows into the slice of structs pointed to by slicePtr, see Row.Decode
+func DecodeRows(rows []Row, slicePtr interface{}) (err error) {
+	sv := reflect.ValueOf(slicePtr)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("decoding rows: expected pointer to slice, but got %T", slicePtr)
+	}
+	sv = sv.Elem()
+
+	var elemType = sv.Type().Elem()
+	for _, row := range rows {
+		elem := reflect.New(elemType)
+
+		err = row.Decode(elem.Interface())
+		if err != nil {
+			return
+		}
+
+		sv.Set(reflect.Append(sv, elem.Elem()))
+	}
+
+	return
+}
+
+type sparqlResponse struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []Row `json:"bindings"`
+	} `json:"results"`
+}
+
+// Query runs the query and returns all result rows
+func (c *Client) Query(ctx context.Context, query string) (rows []Row, err error) {
+	var wait = time.Second
+
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		rows, retryAfter, err = c.do(ctx, query)
+		if err == nil || retryAfter < 0 || attempt >= c.MaxRetries {
+			return
+		}
+
+		// Wait longer after each failed attempt, but if the server tells us how long to wait, we do that
+		if retryAfter == 0 {
+			retryAfter = wait
+			wait *= 2
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// QueryPages runs the query page by page with LIMIT and OFFSET clauses appended and calls page for every page that has rows.
+// The query should have a stable ORDER BY, otherwise rows could be returned twice or never
+func (c *Client) QueryPages(ctx context.Context, query string, page func(rows []Row) error) (err error) {
+	if c.PageSize <= 0 {
+		return fmt.Errorf("paging needs a page size, but it's %d", c.PageSize)
+	}
+
+	for offset := 0; ; offset += c.PageSize {
+		rows, err := c.Query(ctx, fmt.Sprintf("%s\nLIMIT %d OFFSET %d", query, c.PageSize, offset))
+		if err != nil {
+			return err
+		}
+
+		if len(rows) > 0 {
+			err = page(rows)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < c.PageSize {
+			return nil
+		}
+	}
+}
+
+// QueryItemPages selects items page by page using itemQuery, which must select ?item and should have a stable ORDER BY.
+// For every page, query is run with ItemsPlaceholder replaced by the items of that page, so all rows of an item
+// are on the same page, and page is called if there are rows. Paging only stops when itemQuery returns fewer
+// items than PageSize, so pages where query has no rows for any of the items don't end it early
+func (c *Client) QueryItemPages(ctx context.Context, itemQuery, query string, page func(rows []Row) error) (err error) {
+	if !strings.Contains(query, ItemsPlaceholder) {
+		return fmt.Errorf("query doesn't contain the items placeholder %q", ItemsPlaceholder)
+	}
+
+	return c.QueryPages(ctx, itemQuery, func(items []Row) (err error) {
+		var values strings.Builder
+		values.WriteString("VALUES ?item {")
+		for _, item := range items {
+			b, ok := item["item"]
+			if !ok || b.Type != "uri" {
+				return fmt.Errorf("item query returned a row without ?item URI: %v", item)
+			}
+			values.WriteString(" <" + b.Value + ">")
+		}
+		values.WriteString(" }")
+
+		rows, err := c.Query(ctx, strings.Replace(query, ItemsPlaceholder, values.String(), 1))
+		if err != nil {
+			return
+		}
+		if len(rows) == 0 {
+			return
+		}
+
+		return page(rows)
+	})
+}
+
+// do sends a single request. retryAfter is negative if the request should not be retried
+// and zero if it should be retried with the default backoff
+func (c *Client) do(ctx context.Context, query string) (rows []Row, retryAfter time.Duration, err error) {
+	req, err := c.newRequest(ctx, query)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, err
+		}
+		// Network errors are often temporary
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Read a bit of the body, the query service puts error messages there
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, parseRetryAfter(resp.Header.Get("Retry-After")), err
+		}
+		return nil, -1, err
+	}
+
+	var data sparqlResponse
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return data.Results.Bindings, 0, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, query string) (req *http.Request, err error) {
+	var form = url.Values{}
+	form.Set("query", query)
+	form.Set("format", "json")
+
+	if c.UsePOST {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.Endpoint+"?"+form.Encode(), nil)
+		if err != nil {
+			return
+		}
+	}
+
+	req.Header.Set("Accept", "application/sparql-results+json")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	return
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or a date. It returns 0 if the header is missing or invalid
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,135 @@
+package wikidata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeItemServer answers item queries with pages of the items and queries with ItemsPlaceholder
+// replaced with one row for every item of the page that is in rows
+type fakeItemServer struct {
+	items []string
+	rows  map[string]bool
+
+	mu      sync.Mutex
+	queries []string
+}
+
+var (
+	offsetRegex = regexp.MustCompile(`LIMIT (\d+) OFFSET (\d+)`)
+	valuesRegex = regexp.MustCompile(`<http://www\.wikidata\.org/entity/(Q\d+)>`)
+)
+
+func (f *fakeItemServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	f.mu.Unlock()
+
+	var ids []string
+	if strings.Contains(query, "VALUES ?item") {
+		for _, m := range valuesRegex.FindAllStringSubmatch(query, -1) {
+			if f.rows[m[1]] {
+				ids = append(ids, m[1])
+			}
+		}
+	} else {
+		m := offsetRegex.FindStringSubmatch(query)
+		limit, _ := strconv.Atoi(m[1])
+		offset, _ := strconv.Atoi(m[2])
+
+		for i := offset; i < offset+limit && i < len(f.items); i++ {
+			ids = append(ids, f.items[i])
+		}
+	}
+
+	var resp sparqlResponse
+	for _, id := range ids {
+		resp.Results.Bindings = append(resp.Results.Bindings, Row{
+			"item": {Type: "uri", Value: "http://www.wikidata.org/entity/" + id},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/sparql-results+json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestQueryItemPages(t *testing.T) {
+	var tests = []struct {
+		name  string
+		items []string
+		rows  []string
+
+		wantItems   []string
+		wantQueries int
+	}{
+		{
+			name:        "all items have rows",
+			items:       []string{"Q1", "Q2", "Q3", "Q4", "Q5"},
+			rows:        []string{"Q1", "Q2", "Q3", "Q4", "Q5"},
+			wantItems:   []string{"Q1", "Q2", "Q3", "Q4", "Q5"},
+			wantQueries: 6,
+		},
+		{
+			name:        "page without rows",
+			items:       []string{"Q1", "Q2", "Q3", "Q4", "Q5"},
+			rows:        []string{"Q5"},
+			wantItems:   []string{"Q5"},
+			wantQueries: 6,
+		},
+		{
+			name:        "last page is full",
+			items:       []string{"Q1", "Q2", "Q3", "Q4"},
+			rows:        []string{"Q1", "Q4"},
+			wantItems:   []string{"Q1", "Q4"},
+			wantQueries: 5,
+		},
+		{
+			name:        "no items",
+			wantQueries: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeItemServer{items: tt.items, rows: make(map[string]bool)}
+			for _, id := range tt.rows {
+				f.rows[id] = true
+			}
+
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			c := NewClient()
+			c.Endpoint = srv.URL
+			c.PageSize = 2
+
+			var got []string
+			err := c.QueryItemPages(context.Background(), "SELECT ?item WHERE {} ORDER BY ?item", "SELECT ?item WHERE { "+ItemsPlaceholder+" }", func(rows []Row) error {
+				for _, row := range rows {
+					got = append(got, row["item"].EntityID())
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("querying failed: %v", err)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantItems) {
+				t.Errorf("got items %v, want %v", got, tt.wantItems)
+			}
+			if len(f.queries) != tt.wantQueries {
+				t.Errorf("sent %d queries, want %d", len(f.queries), tt.wantQueries)
+			}
+		})
+	}
+}
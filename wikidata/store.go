@@ -1,8 +1,15 @@
 package wikidata
 
-import "strings"
+import (
+	"net/url"
+	"strings"
+	"time"
+)
 
 type Politician struct {
+	// ID is the WikiData ID, e.g. "Q567"
+	ID string
+
 	Name string
 
 	FirstName, LastName string
@@ -10,26 +17,262 @@ type Politician struct {
 	WikiPageTitle  string
 	WikiArticleURL string
 
-	partyHashtag, partyShortname, partyTwittername string
+	// Parties contains all party memberships, including past ones
+	Parties []PartyMembership
+
+	// Positions contains the offices the politician currently holds, e.g. a seat in the Bundestag
+	Positions []Position
+
+	// Image is the name of a photo on Wikimedia Commons, e.g. "Max Mustermann.jpg". Could be empty
+	Image string
 }
 
-// PartyShortname could be empty. It will not include the '#' at the front
-func (p *Politician) PartyShortname() string {
+// Level describes on which political level a position is
+type Level string
+
+const (
+	LevelFederal  Level = "federal"
+	LevelState    Level = "state"
+	LevelEuropean Level = "european"
+	LevelOther    Level = "other"
+)
+
+// Position is an office a politician holds, e.g. "Mitglied des Deutschen Bundestages"
+type Position struct {
+	// statement is the ID of the WikiData statement, it's used for merging rows of the same position
+	statement string
+
+	// ID is the WikiData ID of the position
+	ID string
+
+	Name string
+
+	// ShortName is e.g. "MdB", could be empty
+	ShortName string
+
+	// Level is empty if it's unknown
+	Level Level
+
+	// Start and End are zero if they are unknown or not set
+	Start, End time.Time
+
+	// ParliamentaryGroup, Constituency and State could be empty.
+	// State is the german state the position is in or the constituency belongs to
+	ParliamentaryGroup, Constituency, State string
+}
+
+// addPosition adds the position to the politician. Rows of the same statement are merged
+func (p *Politician) addPosition(pos Position) {
+	for i, existing := range p.Positions {
+		if existing.statement == pos.statement {
+			if existing.ShortName == "" {
+				p.Positions[i].ShortName = pos.ShortName
+			}
+			if existing.ParliamentaryGroup == "" {
+				p.Positions[i].ParliamentaryGroup = pos.ParliamentaryGroup
+			}
+			if existing.Constituency == "" {
+				p.Positions[i].Constituency = pos.Constituency
+			}
+			if existing.State == "" {
+				p.Positions[i].State = pos.State
+			}
+			return
+		}
+	}
+
+	p.Positions = append(p.Positions, pos)
+}
+
+// MainPosition returns the most important position the politician holds, which is the one on the
+// highest level. Positions on the same level are ordered by when they started, the latest one wins
+func (p *Politician) MainPosition() (pos Position, ok bool) {
+	var rank = map[Level]int{
+		LevelFederal:  4,
+		LevelEuropean: 3,
+		LevelState:    2,
+		LevelOther:    1,
+	}
+
+	for _, candidate := range p.Positions {
+		if !ok || rank[candidate.Level] > rank[pos.Level] ||
+			(rank[candidate.Level] == rank[pos.Level] && candidate.Start.After(pos.Start)) {
+			pos, ok = candidate, true
+		}
+	}
+
+	return
+}
+
+// HasLevel returns whether the politician currently holds a position on one of the given levels
+func (p *Politician) HasLevel(levels ...Level) bool {
+	for _, pos := range p.Positions {
+		for _, l := range levels {
+			if pos.Level == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Constituency returns the constituency of the most important position that has one, e.g. "Bundestagswahlkreis München-Nord".
+// Could be empty
+func (p *Politician) Constituency() string {
+	return p.positionField(func(pos Position) string { return pos.Constituency })
+}
+
+// ParliamentaryGroup returns the parliamentary group of the most important position that has one. Could be empty
+func (p *Politician) ParliamentaryGroup() string {
+	return p.positionField(func(pos Position) string { return pos.ParliamentaryGroup })
+}
+
+// State returns the german state of the most important position that is associated with one. Could be empty
+func (p *Politician) State() string {
+	return p.positionField(func(pos Position) string { return pos.State })
+}
+
+// positionField returns the first non-empty field of the main position or any other position
+func (p *Politician) positionField(field func(pos Position) string) string {
+	if main, ok := p.MainPosition(); ok && field(main) != "" {
+		return field(main)
+	}
+
+	for _, pos := range p.Positions {
+		if f := field(pos); f != "" {
+			return f
+		}
+	}
+
 	return ""
+}
 
-	if p.partyHashtag != "" {
-		return strings.TrimPrefix(p.partyHashtag, "#")
+// ImageURL returns the URL of the photo on Wikimedia Commons. ok is false if there is no photo
+func (p *Politician) ImageURL() (u string, ok bool) {
+	if p.Image == "" {
+		return
 	}
 
-	if p.partyTwittername != "" {
-		return p.partyTwittername
+	return "https://commons.wikimedia.org/wiki/Special:FilePath/" + url.PathEscape(p.Image), true
+}
+
+// PartyMembership is a membership of a politician in a party
+type PartyMembership struct {
+	// ID is the WikiData ID of the party
+	ID string
+
+	Name string
+
+	// ShortName, Hashtag and TwitterName could be empty. Hashtag doesn't include the '#' at the front
+	ShortName, Hashtag, TwitterName string
+
+	// Start and End are zero if they are unknown or not set
+	Start, End time.Time
+}
+
+// Current returns whether this membership is active at the given time
+func (m *PartyMembership) Current(at time.Time) bool {
+	return (m.Start.IsZero() || !m.Start.After(at)) && (m.End.IsZero() || m.End.After(at))
+}
+
+// addParty adds the membership to the politician. Memberships that only differ in
+// translated fields (e.g. more than one hashtag) are merged
+func (p *Politician) addParty(m PartyMembership) {
+	for i, existing := range p.Parties {
+		if existing.ID == m.ID && existing.Start.Equal(m.Start) && existing.End.Equal(m.End) {
+			if existing.ShortName == "" {
+				p.Parties[i].ShortName = m.ShortName
+			}
+			if existing.Hashtag == "" {
+				p.Parties[i].Hashtag = m.Hashtag
+			}
+			if existing.TwitterName == "" {
+				p.Parties[i].TwitterName = m.TwitterName
+			}
+			return
+		}
 	}
 
-	return p.partyShortname
+	p.Parties = append(p.Parties, m)
+}
+
+// CurrentParty returns the party the politician is currently a member of.
+// If there are multiple current memberships, the one that started last is returned.
+// ok is false if there is no current membership or if it can't be decided which one is the current one
+func (p *Politician) CurrentParty() (party PartyMembership, ok bool) {
+	var (
+		now        = time.Now()
+		candidates int
+	)
+
+	for _, m := range p.Parties {
+		if !m.Current(now) {
+			continue
+		}
+		candidates++
+
+		if !ok || m.Start.After(party.Start) {
+			party, ok = m, true
+		}
+	}
+
+	// If multiple parties are current and we don't know when any of them started, we can't decide
+	if candidates > 1 && party.Start.IsZero() {
+		return PartyMembership{}, false
+	}
+
+	return
+}
+
+// PartyShortname returns the short name of the current party, e.g. "SPD".
+// Could be empty. It will not include the '#' at the front
+func (p *Politician) PartyShortname() string {
+	party, _ := p.CurrentParty()
+	if party.ShortName != "" {
+		return party.ShortName
+	}
+
+	return party.Hashtag
+}
+
+// PartyHashtag returns the hashtag of the current party, without the '#' at the front.
+// If the party doesn't have a hashtag, its short name is returned. Could be empty
+func (p *Politician) PartyHashtag() string {
+	party, _ := p.CurrentParty()
+	if party.Hashtag != "" {
+		return party.Hashtag
+	}
+
+	return party.ShortName
+}
+
+// PartyTwitterName returns the twitter handle of the current party, without the '@' at the front. Could be empty
+func (p *Politician) PartyTwitterName() string {
+	party, _ := p.CurrentParty()
+	return strings.TrimPrefix(party.TwitterName, "@")
 }
 
 type PoliticianStore struct {
 	politicians map[string]Politician
+
+	// titles maps WikiData IDs to page titles
+	titles map[string]string
+}
+
+// NewPoliticianStore returns a store that only contains the given politicians, e.g. for tests.
+// Politicians returns the store with all politicians from WikiData
+func NewPoliticianStore(politicians ...Politician) (store PoliticianStore) {
+	store = PoliticianStore{
+		politicians: make(map[string]Politician, len(politicians)),
+		titles:      make(map[string]string, len(politicians)),
+	}
+
+	for _, p := range politicians {
+		store.politicians[p.WikiPageTitle] = p
+		store.titles[p.ID] = p.WikiPageTitle
+	}
+
+	return
 }
 
 // Get returns, if possible, a wikipedia article with the given title is in this store
@@ -48,3 +291,63 @@ func (s *PoliticianStore) Contains(pageTitle string) (ok bool) {
 func (s *PoliticianStore) Len() int {
 	return len(s.politicians)
 }
+
+// OrganizationKind describes what kind of institution an Organization is
+type OrganizationKind string
+
+const (
+	KindParty      OrganizationKind = "party"
+	KindParliament OrganizationKind = "parliament"
+	KindMinistry   OrganizationKind = "ministry"
+)
+
+// Organization is a party, parliament or ministry that has a german wikipedia article
+type Organization struct {
+	Kind OrganizationKind
+
+	Name string
+
+	// ShortName is e.g. "CDU" or "BMF", could be empty
+	ShortName string
+
+	// Hashtag and TwitterName could be empty. Hashtag doesn't include the '#' at the front
+	Hashtag, TwitterName string
+
+	WikiPageTitle  string
+	WikiArticleURL string
+}
+
+type OrganizationStore struct {
+	organizations map[string]Organization
+}
+
+// NewOrganizationStore returns a store that only contains the given organizations, e.g. for tests.
+// Organizations returns the store with all organizations from WikiData
+func NewOrganizationStore(organizations ...Organization) (store OrganizationStore) {
+	store = OrganizationStore{
+		organizations: make(map[string]Organization, len(organizations)),
+	}
+
+	for _, o := range organizations {
+		store.organizations[o.WikiPageTitle] = o
+	}
+
+	return
+}
+
+// Get returns, if possible, the organization whose wikipedia article has the given title
+func (s *OrganizationStore) Get(pageTitle string) (o Organization, ok bool) {
+	o, ok = s.organizations[pageTitle]
+	return
+}
+
+// Contains returns true if we have this page title in our store
+func (s *OrganizationStore) Contains(pageTitle string) (ok bool) {
+	_, ok = s.organizations[pageTitle]
+	return
+}
+
+// Len returns the amount of organizations in this store
+func (s *OrganizationStore) Len() int {
+	return len(s.organizations)
+}
@@ -1,12 +1,31 @@
 package wikipedia
 
 import (
+	"encoding/json"
 	"net/url"
 	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of events in the recentchange stream
+const (
+	TypeEdit       = "edit"
+	TypeNew        = "new"
+	TypeLog        = "log"
+	TypeCategorize = "categorize"
+)
+
+// Log types of log events that we look at
+const (
+	LogTypeDelete  = "delete"
+	LogTypeProtect = "protect"
 )
 
 type Event struct {
+	// Type is one of TypeEdit, TypeNew, TypeLog or TypeCategorize
 	Type string `json:"type"`
 
 	Meta struct {
@@ -35,6 +54,118 @@ type Event struct {
 
 	// Wiki name, e.g. "dewiki" or "enwiki"
 	Wiki string `json:"wiki"`
+
+	// Namespace of the page, e.g. 0 for articles and 14 for categories
+	Namespace int `json:"namespace"`
+
+	// The following fields are only set for log events
+	LogID     int       `json:"log_id"`
+	LogType   string    `json:"log_type"`
+	LogAction string    `json:"log_action"`
+	LogParams LogParams `json:"log_params"`
+}
+
+// LogParams contains the parameters of a log event. Only the ones we use are decoded
+type LogParams struct {
+	// Description of a protection, e.g. "[Bearbeiten=Nur angemeldeten, nicht neuen Benutzern erlauben] (unbeschränkt)"
+	Description string `json:"description"`
+}
+
+// UnmarshalJSON decodes the parameters. Log events without parameters have an empty array instead of an object
+func (p *LogParams) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '{' {
+		*p = LogParams{}
+		return nil
+	}
+
+	type plain LogParams
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+// IsDeletion returns whether this event is the deletion of a page
+func (e *Event) IsDeletion() bool {
+	return e.Type == TypeLog && e.LogType == LogTypeDelete && e.LogAction == "delete"
+}
+
+// IsProtection returns whether this event changes the protection of a page.
+// LogAction is "protect", "modify" or "unprotect" for these events
+func (e *Event) IsProtection() bool {
+	return e.Type == TypeLog && e.LogType == LogTypeProtect
+}
+
+// categorizeComment matches the page in the comment of categorize events, e.g. "[[:Max Mustermann]] zur Kategorie hinzugefügt"
+var categorizeComment = regexp.MustCompile(`^\[\[:([^\]|]+)[^\]]*\]\]`)
+
+// PageTitle returns the title of the article this event is about. For categorize events that's
+// the page that was added to or removed from the category in Title, for all other events it's Title
+func (e *Event) PageTitle() string {
+	if e.Type != TypeCategorize {
+		return e.Title
+	}
+
+	m := categorizeComment.FindStringSubmatch(e.Comment)
+	if m == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(m[1])
+}
+
+// NamespaceTalk is the namespace of talk pages of articles
+const NamespaceTalk = 1
+
+const talkPrefix = "Diskussion:"
+
+// IsTalkPage returns whether the event is about a talk page ("Diskussion:") of an article
+func (e *Event) IsTalkPage() bool {
+	return e.Namespace == NamespaceTalk && strings.HasPrefix(e.Title, talkPrefix)
+}
+
+// SubjectTitle returns the title of the article this event is about. For talk pages that's the
+// title without the "Diskussion:" prefix, for all other events it's the same as PageTitle
+func (e *Event) SubjectTitle() string {
+	if e.IsTalkPage() {
+		return strings.TrimPrefix(e.Title, talkPrefix)
+	}
+	return e.PageTitle()
+}
+
+// sectionComment matches the section in an edit comment, e.g. "/* Wahlkampf */ neuer Abschnitt"
+var sectionComment = regexp.MustCompile(`^/\*\s*(.+?)\s*\*/\s*(.*)$`)
+
+// NewSection returns the name of the section if this edit added a new section to the page
+func (e *Event) NewSection() (section string, ok bool) {
+	m := sectionComment.FindStringSubmatch(e.Comment)
+	if m == nil || !strings.HasPrefix(strings.ToLower(m[2]), "neuer abschnitt") {
+		return
+	}
+
+	return m[1], true
+}
+
+// SectionURL returns the URL of the given section of the page of this event
+func (e *Event) SectionURL(section string) string {
+	var u = url.URL{
+		Scheme:   "https",
+		Host:     "de.wikipedia.org",
+		Path:     "/wiki/" + strings.ReplaceAll(e.Title, " ", "_"),
+		Fragment: strings.ReplaceAll(section, " ", "_"),
+	}
+
+	return u.String()
+}
+
+// CategoryRemoved returns whether a categorize event removed the page from the category
+func (e *Event) CategoryRemoved() bool {
+	return e.Type == TypeCategorize && strings.Contains(e.Comment, "entfernt")
+}
+
+// Category returns the name of the category of a categorize event without the namespace prefix
+func (e *Event) Category() string {
+	if i := strings.IndexByte(e.Title, ':'); i >= 0 {
+		return e.Title[i+1:]
+	}
+	return e.Title
 }
 
 type Length struct {
@@ -47,6 +178,28 @@ type Revision struct {
 	New int `json:"new"`
 }
 
+// LogFields returns key-value pairs that identify the event in log lines: the revision ID for edits
+// and page creations or the log ID for log events, plus the title. Adding them to every line about
+// the event makes it possible to find out why it was or wasn't posted
+func (e *Event) LogFields() []interface{} {
+	switch {
+	case e.Type == TypeLog && e.LogID != 0:
+		return []interface{}{"log_id", e.LogID, "title", e.PageTitle()}
+	case e.Revision.New != 0:
+		return []interface{}{"rev", e.Revision.New, "title", e.PageTitle()}
+	default:
+		return []interface{}{"title", e.PageTitle()}
+	}
+}
+
+// Time returns the time of the event. It falls back to the current time for events without timestamp
+func (e *Event) Time() time.Time {
+	if e.Timestamp == 0 {
+		return time.Now()
+	}
+	return time.Unix(int64(e.Timestamp), 0)
+}
+
 func (e *Event) SizeDifference() int {
 	// Need to check if negative because something could be deleted
 	size := e.Length.New - e.Length.Old
@@ -57,6 +210,52 @@ func (e *Event) SizeDifference() int {
 	return size
 }
 
+// PageURL returns the URL of the page this event is about
+func (e *Event) PageURL() string {
+	var u = url.URL{
+		Scheme: "https",
+		Host:   "de.wikipedia.org",
+		Path:   "/wiki/" + strings.ReplaceAll(e.PageTitle(), " ", "_"),
+	}
+
+	return u.String()
+}
+
+// LogURL returns the URL of the log entries of the given log type for the page of this event
+func (e *Event) LogURL() string {
+	var u = url.URL{
+		Scheme: "https",
+		Host:   "de.wikipedia.org",
+		Path:   "/w/index.php",
+	}
+
+	var q = make(url.Values, 3)
+
+	q.Set("title", "Spezial:Logbuch")
+	q.Set("type", e.LogType)
+	q.Set("page", e.Title)
+
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// RevisionURL returns the URL of the new revision, e.g. for pages that were just created
+func (e *Event) RevisionURL() (us string, ok bool) {
+	if e.Revision.New == 0 {
+		return
+	}
+
+	var u = url.URL{
+		Scheme:   "https",
+		Host:     "de.wikipedia.org",
+		Path:     "/w/index.php",
+		RawQuery: "oldid=" + strconv.Itoa(e.Revision.New),
+	}
+
+	return u.String(), true
+}
+
 // DiffURL returns the URL for seeing the difference between two versions of an article
 func (e *Event) DiffURL() (us string, ok bool) {
 	pageSlug := path.Base(e.Meta.URI)
@@ -0,0 +1,5 @@
+package wikipedia
+
+import "github.com/xarantolus/poliwiki/logging"
+
+var logger = logging.With("component", "stream")
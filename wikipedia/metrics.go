@@ -0,0 +1,14 @@
+package wikipedia
+
+import "github.com/xarantolus/poliwiki/metrics"
+
+var (
+	eventsReceived = metrics.NewCounterVec("poliwiki_stream_events_total", "Events received from the stream, before any filtering", "wiki")
+
+	streamConnected  = metrics.NewGauge("poliwiki_stream_connected", "1 if the stream is connected, 0 otherwise")
+	streamReconnects = metrics.NewCounter("poliwiki_stream_reconnects_total", "Reconnects to the stream after errors or disconnects")
+	streamBackoff    = metrics.NewGauge("poliwiki_stream_backoff_seconds", "Time we wait before the next reconnect, 0 if connected")
+
+	streamDropped = metrics.NewCounter("poliwiki_stream_dropped_total", "Events dropped because the channel was full")
+	streamSpilled = metrics.NewCounter("poliwiki_stream_spilled_total", "Events written to disk because the channel was full")
+)
@@ -0,0 +1,322 @@
+package wikipedia
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// What the streamer does if events arrive faster than they are read from the channel
+const (
+	// OverflowBlock stops reading from the stream until there's space again. If that takes too long,
+	// wikimedia drops the connection and we reconnect, which loses all events in between
+	OverflowBlock = "block"
+
+	// OverflowDropOldest removes the oldest event from the channel to make space for the new one
+	OverflowDropOldest = "drop_oldest"
+
+	// OverflowSpill writes events to a file on disk until there's space again. Nothing is lost,
+	// but events can arrive a lot later than they happened
+	OverflowSpill = "spill"
+)
+
+// StreamStats are counters about the stream and events that didn't fit into the channel
+type StreamStats struct {
+	// Connected is true while the stream is connected
+	Connected bool
+	// LastEvent is the time the last event was received from the stream, including ones that were filtered out
+	LastEvent time.Time
+
+	// Dropped is the number of events that were dropped by OverflowDropOldest
+	Dropped int64
+	// Spilled is the number of events that were written to disk by OverflowSpill
+	Spilled int64
+	// Spilling is the number of events that are currently waiting on disk
+	Spilling int
+}
+
+// Stats returns the counters of the streamer
+func (s *Streamer) Stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+func (s *Streamer) count(f func(st *StreamStats)) {
+	s.mu.Lock()
+	f(&s.stats)
+	s.mu.Unlock()
+}
+
+// deliverFunc returns the function that puts events into out according to the overflow policy.
+// stop must be called before out is closed
+func (s *Streamer) deliverFunc(ctx context.Context, out chan Event) (deliver func(e Event) error, stop func()) {
+	stop = func() {}
+
+	block := func(e Event) error {
+		select {
+		case out <- e:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch s.Overflow {
+	case OverflowDropOldest:
+		return func(e Event) error {
+			for {
+				select {
+				case out <- e:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+
+				// The channel is full, so we remove the oldest event. The reader could have been faster, that's fine too
+				select {
+				case old := <-out:
+					var dropped int64
+					s.count(func(st *StreamStats) {
+						st.Dropped++
+						dropped = st.Dropped
+					})
+					streamDropped.Inc()
+
+					// Don't flood the log if the consumer is stuck for a long time
+					if dropped%100 == 1 {
+						logger.Warn("channel is full, dropped oldest event", append(old.LogFields(), "dropped", dropped)...)
+					}
+				default:
+				}
+			}
+		}, stop
+	case OverflowSpill:
+		var file = s.SpillFile
+		if file == "" {
+			file = defaultSpillFile()
+		}
+
+		q, err := openSpillQueue(file)
+		if err != nil {
+			logger.Error("cannot spill to disk, blocking instead", "file", file, "err", err)
+			return block, stop
+		}
+
+		var (
+			wakeup    = make(chan struct{}, 1)
+			forwarded = make(chan struct{})
+		)
+		go func() {
+			defer close(forwarded)
+			s.forwardSpilled(ctx, q, out, wakeup)
+		}()
+		stop = func() { <-forwarded }
+
+		return func(e Event) error {
+			// As long as there are events on disk, new events must go there too, otherwise they would overtake them
+			if q.Len() == 0 {
+				select {
+				case out <- e:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+			}
+
+			err := q.Push(e)
+			if err != nil {
+				logger.Error("spilling event to disk failed, blocking instead", append(e.LogFields(), "err", err)...)
+				return block(e)
+			}
+
+			s.count(func(st *StreamStats) {
+				st.Spilled++
+				st.Spilling = q.Len()
+			})
+			streamSpilled.Inc()
+
+			select {
+			case wakeup <- struct{}{}:
+			default:
+			}
+
+			return nil
+		}, stop
+	default:
+		if s.Overflow != "" && s.Overflow != OverflowBlock {
+			logger.Warn("unknown overflow policy, blocking instead", "policy", s.Overflow)
+		}
+		return block, stop
+	}
+}
+
+// forwardSpilled moves events from the spill queue to out, oldest first
+func (s *Streamer) forwardSpilled(ctx context.Context, q *spillQueue, out chan<- Event, wakeup <-chan struct{}) {
+	defer q.Close()
+
+	for {
+		e, ok, err := q.Next()
+		if err != nil {
+			// There's no way to recover these events, so we start over
+			logger.Error("reading spilled events failed, dropping them", "dropped", q.Len(), "err", err)
+			q.Reset()
+			var lost int
+			s.count(func(st *StreamStats) {
+				lost = st.Spilling
+				st.Dropped += int64(lost)
+				st.Spilling = 0
+			})
+			streamDropped.Add(float64(lost))
+			continue
+		}
+
+		if !ok {
+			select {
+			case <-wakeup:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case out <- e:
+		case <-ctx.Done():
+			return
+		}
+
+		q.Done()
+		s.count(func(st *StreamStats) {
+			st.Spilling = q.Len()
+		})
+	}
+}
+
+func defaultSpillFile() string {
+	return filepath.Join(os.TempDir(), "poliwiki-spill.jsonl")
+}
+
+// spillQueue is a queue of events in a file, one JSON object per line.
+// The file is emptied whenever all events have been read
+type spillQueue struct {
+	mu sync.Mutex
+
+	w *os.File
+	r *os.File
+	b *bufio.Reader
+
+	// n is the number of events that were pushed, but not done yet
+	n int
+}
+
+// openSpillQueue creates an empty queue in the file. Events from previous runs are discarded,
+// they are outdated anyways
+func openSpillQueue(name string) (q *spillQueue, err error) {
+	w, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+
+	r, err := os.Open(name)
+	if err != nil {
+		w.Close()
+		return
+	}
+
+	return &spillQueue{
+		w: w,
+		r: r,
+		b: bufio.NewReader(r),
+	}, nil
+}
+
+// Push appends the event to the queue
+func (q *spillQueue) Push(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err = q.w.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	q.n++
+
+	return nil
+}
+
+// Next reads the next event. ok is false if all pushed events were read.
+// The event still counts as queued until Done is called
+func (q *spillQueue) Next() (e Event, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.n == 0 {
+		return
+	}
+
+	line, err := q.b.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(line, &e)
+	return e, err == nil, err
+}
+
+// Done marks the event that was returned by Next as processed
+func (q *spillQueue) Done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.n--
+	if q.n == 0 {
+		q.reset()
+	}
+}
+
+// Len returns the number of events that are not done yet
+func (q *spillQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.n
+}
+
+// Reset removes all events
+func (q *spillQueue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.n = 0
+	q.reset()
+}
+
+// reset empties the file. The lock must be held
+func (q *spillQueue) reset() {
+	// Writes happen at the end because of O_APPEND, so we only need to move the reader
+	_ = q.w.Truncate(0)
+	_, _ = q.r.Seek(0, 0)
+	q.b.Reset(q.r)
+}
+
+func (q *spillQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.w.Close()
+	q.r.Close()
+	os.Remove(q.w.Name())
+}
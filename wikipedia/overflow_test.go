@@ -0,0 +1,124 @@
+package wikipedia_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+// edits returns edits of the articles "Artikel <from>" to "Artikel <to-1>" and their titles
+func edits(from, to int) (events []wikipedia.Event, titles []string) {
+	for i := from; i < to; i++ {
+		e := streamtest.Edit(fmt.Sprintf("Artikel %d", i), 100, 200)
+		events = append(events, e)
+		titles = append(titles, e.Title)
+	}
+	return
+}
+
+// waitForStats waits until ok returns true for the stats of the streamer, which means that the stream
+// delivered everything it could while nobody was reading from the channel
+func waitForStats(t *testing.T, s *wikipedia.Streamer, ok func(st wikipedia.StreamStats) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(s.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, got stats %+v", s.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readSlowly reads n events from the channel, taking a bit of time for each one
+func readSlowly(t *testing.T, c <-chan wikipedia.Event, n int) (titles []string) {
+	t.Helper()
+
+	for len(titles) < n {
+		select {
+		case e := <-c:
+			titles = append(titles, e.Title)
+			time.Sleep(100 * time.Microsecond)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after reading %d of %d events", len(titles), n)
+		}
+	}
+	return
+}
+
+func TestStreamOverflowDropOldest(t *testing.T) {
+	events, titles := edits(0, 100)
+
+	srv := streamtest.NewServer(streamtest.Session{Events: events, Hang: true})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &wikipedia.Streamer{
+		URL:      srv.URL,
+		Overflow: wikipedia.OverflowDropOldest,
+	}
+	c := s.Stream(ctx, nil)
+
+	// The channel has space for 25 events, all older ones must be dropped
+	waitForStats(t, s, func(st wikipedia.StreamStats) bool { return st.Dropped == 75 })
+
+	if got := readSlowly(t, c, 25); !equalStrings(got, titles[75:]) {
+		t.Errorf("got events %q, want the newest ones %q", got, titles[75:])
+	}
+
+	cancel()
+	for range c {
+	}
+
+	if st := s.Stats(); st.Dropped != 75 || st.Spilled != 0 {
+		t.Errorf("got stats %+v, want 75 dropped events", st)
+	}
+}
+
+func TestStreamOverflowSpill(t *testing.T) {
+	// The second batch arrives after a reconnect, while the first one is still waiting on disk
+	first, firstTitles := edits(0, 100)
+	second, secondTitles := edits(100, 120)
+
+	srv := streamtest.NewServer(
+		streamtest.Session{Events: first},
+		streamtest.Session{Events: second, Hang: true},
+	)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &wikipedia.Streamer{
+		URL:       srv.URL,
+		Wait:      func(ctx context.Context, d time.Duration) {},
+		Overflow:  wikipedia.OverflowSpill,
+		SpillFile: filepath.Join(t.TempDir(), "spill.jsonl"),
+	}
+	c := s.Stream(ctx, nil)
+
+	// The channel has space for 25 events, everything after that goes to disk
+	waitForStats(t, s, func(st wikipedia.StreamStats) bool { return st.Spilled == 95 })
+
+	want := append(firstTitles, secondTitles...)
+	if got := readSlowly(t, c, len(want)); !equalStrings(got, want) {
+		t.Errorf("got events %q, want all of them in order %q", got, want)
+	}
+
+	waitForStats(t, s, func(st wikipedia.StreamStats) bool { return st.Spilling == 0 })
+
+	cancel()
+	for range c {
+	}
+
+	if st := s.Stats(); st.Dropped != 0 || st.Spilled != 95 {
+		t.Errorf("got stats %+v, want 95 spilled and no dropped events", st)
+	}
+}
@@ -1,10 +1,11 @@
 package wikipedia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -12,10 +13,83 @@ import (
 // filterFunc gets the change event of the article to make that decision.
 // StreamEdits will try to reconnect forever
 func StreamEdits(filterFunc func(event *Event) bool) <-chan Event {
+	return StreamEvents([]string{TypeEdit}, filterFunc)
+}
+
+// StreamEvents is like StreamEdits, but returns all events with one of the given types, e.g. TypeNew or TypeLog
+func StreamEvents(types []string, filterFunc func(event *Event) bool) <-chan Event {
+	s := Streamer{
+		Types: types,
+	}
+	return s.Stream(context.Background(), filterFunc)
+}
+
+const (
+	recentChangesURL = "https://stream.wikimedia.org/v2/stream/recentchange"
+)
+
+var noTimeoutClient = http.Client{}
+
+// Streamer streams events from an EventStreams endpoint. The zero value streams edits from wikimedia
+type Streamer struct {
+	// URL of the recentchange stream. Defaults to the one of wikimedia
+	URL string
+
+	// Client is used for connecting. Defaults to a client without timeout, as the stream never ends
+	Client *http.Client
+
+	// Types of events that are returned. Defaults to TypeEdit
+	Types []string
+
+	// Wait is called with the time to wait before reconnecting. Defaults to sleeping until the time
+	// is over or the context is cancelled. Tests can replace it to record the backoff without waiting
+	Wait func(ctx context.Context, d time.Duration)
+
+	// Overflow is what happens if events arrive faster than they are read from the channel:
+	// OverflowBlock, OverflowDropOldest or OverflowSpill. Defaults to OverflowBlock
+	Overflow string
+
+	// SpillFile is the file used by OverflowSpill. Defaults to a file in the temp directory
+	SpillFile string
+
+	mu    sync.Mutex
+	stats StreamStats
+}
+
+// Stream returns all events of the german wiki for that filterFunc returns true. If filterFunc is nil, all events
+// are returned. It tries to reconnect until ctx is cancelled, after that the returned channel is closed
+func (s *Streamer) Stream(ctx context.Context, filterFunc func(event *Event) bool) <-chan Event {
 	// Buffer of 25 should be more than enough
 	var resultChannel = make(chan Event, 25)
 
+	var (
+		streamURL = s.URL
+		client    = s.Client
+		types     = s.Types
+		wait      = s.Wait
+	)
+	if streamURL == "" {
+		streamURL = recentChangesURL
+	}
+	if client == nil {
+		client = &noTimeoutClient
+	}
+	if len(types) == 0 {
+		types = []string{TypeEdit}
+	}
+	if wait == nil {
+		wait = sleepContext
+	}
+	if filterFunc == nil {
+		filterFunc = func(*Event) bool { return true }
+	}
+
+	deliver, stop := s.deliverFunc(ctx, resultChannel)
+
 	go func() {
+		defer close(resultChannel)
+		defer stop()
+
 		// When errors happen, we don't reconnect instantly.
 		// We wait for some time, and if we aren't able to reconnect, we wait even longer
 		var (
@@ -24,54 +98,85 @@ func StreamEdits(filterFunc func(event *Event) bool) <-chan Event {
 		)
 
 		for {
-			log.Println("[StreamEdits] Connecting...")
-
-			err := populateStreamEdits(filterFunc, resultChannel, func() {
-				log.Println("[StreamEdits] Connected, processing events")
+			logger.Info("connecting", "url", streamURL)
+
+			err := populateStreamEdits(ctx, client, streamURL, types, filterFunc, deliver, func() {
+				logger.Info("connected, processing events")
+				streamConnected.Set(1)
+				streamBackoff.Set(0)
+				s.count(func(st *StreamStats) { st.Connected = true })
+			}, func() {
+				now := time.Now()
+				s.count(func(st *StreamStats) { st.LastEvent = now })
 			})
-			if err != nil {
-				log.Printf("[StreamEdits] %s\n", err.Error())
+			streamConnected.Set(0)
+			s.count(func(st *StreamStats) { st.Connected = false })
+			if ctx.Err() != nil {
+				return
 			}
-
-			if time.Since(lastErrorTime) < 5*time.Minute {
-				backoff *= backoff
-			} else {
-				backoff = 2
+			if err != nil {
+				logger.Warn("stream failed", "err", err)
 			}
 
+			waitTime := nextBackoff(&backoff, time.Since(lastErrorTime))
 			lastErrorTime = time.Now()
 
-			// Set wait time depending on how many fails there were, but reconnect within 5 minutes
-			waitTime := time.Duration(backoff) * time.Second
-			if waitTime > 5*time.Minute {
-				waitTime = 5 * time.Minute
-			}
+			logger.Info("waiting before reconnect", "wait", waitTime)
+			streamBackoff.Set(waitTime.Seconds())
+			wait(ctx, waitTime)
+			streamReconnects.Inc()
 
-			log.Printf("[StreamEdits] Waiting %s before reconnect...\n", waitTime)
-			time.Sleep(waitTime)
+			if ctx.Err() != nil {
+				return
+			}
 		}
 	}()
 
 	return resultChannel
 }
 
-const (
-	recentChangesURL = "https://stream.wikimedia.org/v2/stream/recentchange"
-)
+// nextBackoff returns how long to wait before reconnecting. If the last error was less than five minutes ago,
+// we wait longer than last time. The wait time is capped at five minutes
+func nextBackoff(backoff *int, sinceLastError time.Duration) time.Duration {
+	if sinceLastError < 5*time.Minute {
+		*backoff *= *backoff
+	} else {
+		*backoff = 2
+	}
 
-var noTimeoutClient = http.Client{}
+	// Set wait time depending on how many fails there were, but reconnect within 5 minutes.
+	// The backoff is capped too, otherwise it would overflow after a few more errors
+	waitTime := time.Duration(*backoff) * time.Second
+	if waitTime > 5*time.Minute || *backoff > 300 {
+		*backoff = 300
+		waitTime = 5 * time.Minute
+	}
+
+	return waitTime
+}
 
-// populateStreamEdits streams edits from wikimedia and puts them into the events channel
-// if filterFunc returns true for the event. It calls onConnect when the stream starts
-func populateStreamEdits(filterFunc func(event *Event) bool, events chan<- Event, onConnect func()) (err error) {
-	req, err := http.NewRequest(http.MethodGet, recentChangesURL, nil)
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// populateStreamEdits streams events with one of the given types from wikimedia and calls deliver with them
+// if filterFunc returns true for the event. It calls onConnect when the stream starts and onEvent for every
+// event that was received, even if it's filtered out
+func populateStreamEdits(ctx context.Context, client *http.Client, streamURL string, types []string, filterFunc func(event *Event) bool, deliver func(e Event) error, onConnect, onEvent func()) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
 	if err != nil {
 		return
 	}
 
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := noTimeoutClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return
 	}
@@ -88,21 +193,41 @@ func populateStreamEdits(filterFunc func(event *Event) bool, events chan<- Event
 	var event = new(Event)
 
 	for dec.More() {
+		// Fields that are missing in an event must not keep the value of the previous event
+		*event = Event{}
+
 		err = dec.Decode(event)
 		if err != nil {
 			break
 		}
 
-		// If it's not an edit in the german wiki, we skip it.
+		eventsReceived.With(event.Wiki).Inc()
+		onEvent()
+
+		// If it's not an event we want in the german wiki, we skip it.
 		// Also skip bot edits and articles without titles (if they even exist?)
-		if event.Bot || event.Type != "edit" || event.Wiki != "dewiki" || event.Title == "" {
+		if event.Bot || !containsType(types, event.Type) || event.Wiki != "dewiki" || event.PageTitle() == "" {
 			continue
 		}
 
 		if filterFunc(event) {
-			events <- *event
+			logger.Debug("accepted event", append(event.LogFields(), "type", event.Type)...)
+
+			err = deliver(*event)
+			if err != nil {
+				return
+			}
 		}
 	}
 
 	return
 }
+
+func containsType(types []string, t string) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package wikipedia_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+	"github.com/xarantolus/poliwiki/wikipedia/streamtest"
+)
+
+// waiter records the wait times of a Streamer without waiting. It cancels the stream after max waits
+type waiter struct {
+	max    int
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (w *waiter) wait(ctx context.Context, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waits = append(w.waits, d)
+	if len(w.waits) >= w.max {
+		w.cancel()
+	}
+}
+
+func (w *waiter) recorded() []time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]time.Duration(nil), w.waits...)
+}
+
+// stream streams from the server until it was waited maxWaits times or timeout is over and returns all titles
+func stream(t *testing.T, srv *streamtest.Server, types []string, maxWaits int, timeout time.Duration) (titles []string, w *waiter) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	w = &waiter{max: maxWaits, cancel: cancel}
+
+	s := &wikipedia.Streamer{
+		URL:   srv.URL,
+		Types: types,
+		Wait:  w.wait,
+	}
+
+	for e := range s.Stream(ctx, nil) {
+		titles = append(titles, e.Title)
+	}
+
+	return titles, w
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStreamFilters(t *testing.T) {
+	bot := streamtest.Edit("Bot-Edit", 100, 200)
+	bot.Bot = true
+
+	english := streamtest.Edit("English", 100, 200)
+	english.Wiki = "enwiki"
+
+	noTitle := streamtest.Edit("", 100, 200)
+
+	events := []wikipedia.Event{
+		streamtest.Edit("Max Mustermann", 100, 200),
+		bot,
+		english,
+		noTitle,
+		streamtest.NewPage("Neue Seite", 500),
+		streamtest.Log("Geschützt", wikipedia.LogTypeProtect, "protect"),
+		streamtest.Edit("Erika Mustermann", 200, 100),
+	}
+
+	var tests = []struct {
+		types []string
+		want  []string
+	}{
+		{nil, []string{"Max Mustermann", "Erika Mustermann"}},
+		{[]string{wikipedia.TypeNew, wikipedia.TypeLog}, []string{"Neue Seite", "Geschützt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.types), func(t *testing.T) {
+			srv := streamtest.NewServer(streamtest.Session{Events: events})
+			defer srv.Close()
+
+			got, _ := stream(t, srv, tt.types, 1, 5*time.Second)
+			if !equalStrings(got, tt.want) {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamReconnect(t *testing.T) {
+	srv := streamtest.NewServer(
+		streamtest.Session{Events: []wikipedia.Event{streamtest.Edit("Erste", 1, 2)}},
+		streamtest.Session{Status: http.StatusInternalServerError},
+		streamtest.Session{Events: []wikipedia.Event{streamtest.Edit("Zweite", 1, 2)}, Delay: 10 * time.Millisecond},
+	)
+	defer srv.Close()
+
+	// The fourth connection gets a 503, after that we stop
+	got, w := stream(t, srv, nil, 4, 5*time.Second)
+
+	if want := []string{"Erste", "Zweite"}; !equalStrings(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+	if c := srv.Connections(); c != 4 {
+		t.Errorf("got %d connections, want 4", c)
+	}
+	if n := len(w.recorded()); n != 4 {
+		t.Errorf("waited %d times, want 4", n)
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	// Every connection fails, so the wait time grows until it reaches the maximum of five minutes
+	srv := streamtest.NewServer()
+	defer srv.Close()
+
+	_, w := stream(t, srv, nil, 6, 5*time.Second)
+
+	want := []time.Duration{
+		2 * time.Second,
+		4 * time.Second,
+		16 * time.Second,
+		256 * time.Second,
+		5 * time.Minute,
+		5 * time.Minute,
+	}
+
+	got := w.recorded()
+	if len(got) != len(want) {
+		t.Fatalf("got waits %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wait %d: got %v, want %v", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestStreamMalformedEvent(t *testing.T) {
+	srv := streamtest.NewServer(
+		streamtest.Session{
+			Events: []wikipedia.Event{streamtest.Edit("Vorher", 1, 2)},
+			Raw:    "kein JSON\n",
+			Hang:   true,
+		},
+		streamtest.Session{Events: []wikipedia.Event{streamtest.Edit("Nachher", 1, 2)}},
+	)
+	defer srv.Close()
+
+	got, _ := stream(t, srv, nil, 2, 5*time.Second)
+
+	if want := []string{"Vorher", "Nachher"}; !equalStrings(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+	if c := srv.Connections(); c < 2 {
+		t.Errorf("got %d connections, the stream should reconnect after a malformed event", c)
+	}
+}
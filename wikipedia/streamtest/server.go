@@ -0,0 +1,230 @@
+// Package streamtest provides a fake EventStreams server for testing code that streams recent changes.
+// It replays canned events and can simulate disconnects, slow streams and HTTP errors without network access
+package streamtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xarantolus/poliwiki/wikipedia"
+)
+
+// Session describes what the server does during one connection. The first connection gets the first session,
+// the second connection the second one and so on. Once all sessions are used up, the server responds with
+// 503 Service Unavailable
+type Session struct {
+	// Status is the HTTP status code of the response. Defaults to 200.
+	// Sessions with any other status code don't send events
+	Status int
+
+	// Events are sent in order, each one encoded as JSON
+	Events []wikipedia.Event
+
+	// Raw is sent after the events as-is, e.g. for sending invalid data
+	Raw string
+
+	// Delay is the time the server waits before each event, which simulates a slow stream
+	Delay time.Duration
+
+	// Hang keeps the connection open after everything was sent, until the client disconnects or the server is closed.
+	// If it's false, the server disconnects after sending everything
+	Hang bool
+}
+
+// Server is a fake EventStreams server
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	sessions    []Session
+	connections int
+	requests    []*http.Request
+
+	closing chan struct{}
+}
+
+// NewServer starts a server that plays the given sessions. Use URL as the stream URL
+func NewServer(sessions ...Session) *Server {
+	s := &Server{
+		sessions: sessions,
+		closing:  make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Close stops the server, also ending all hanging connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.closing:
+	default:
+		close(s.closing)
+	}
+	s.mu.Unlock()
+
+	s.Server.Close()
+}
+
+// AddSession adds a session that is played after the existing ones
+func (s *Server) AddSession(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = append(s.sessions, session)
+}
+
+// Connections returns how often clients connected to the server
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections
+}
+
+// Requests returns all requests the server received, e.g. for checking headers
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var (
+		session Session
+		ok      = s.connections < len(s.sessions)
+	)
+	if ok {
+		session = s.sessions[s.connections]
+	}
+	s.connections++
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "no more sessions", http.StatusServiceUnavailable)
+		return
+	}
+
+	if session.Status != 0 && session.Status != http.StatusOK {
+		http.Error(w, http.StatusText(session.Status), session.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	enc := json.NewEncoder(w)
+	for _, e := range session.Events {
+		if !s.wait(r, session.Delay) {
+			return
+		}
+
+		if enc.Encode(e) != nil {
+			return
+		}
+		flush()
+	}
+
+	if session.Raw != "" {
+		if _, err := fmt.Fprint(w, session.Raw); err != nil {
+			return
+		}
+		flush()
+	}
+
+	if session.Hang {
+		select {
+		case <-r.Context().Done():
+		case <-s.closing:
+		}
+	}
+}
+
+// wait waits for d and returns false if the connection was closed in the meantime
+func (s *Server) wait(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	case <-s.closing:
+		return false
+	}
+}
+
+var (
+	revisionMu sync.Mutex
+	revision   = 1000
+)
+
+func nextRevision() int {
+	revisionMu.Lock()
+	defer revisionMu.Unlock()
+
+	revision++
+	return revision
+}
+
+// Edit returns an edit of the article on the german wikipedia that changes its length from oldLength to newLength
+func Edit(title string, oldLength, newLength int) wikipedia.Event {
+	e := event(wikipedia.TypeEdit, title)
+	e.Revision.Old = nextRevision()
+	e.Revision.New = nextRevision()
+	e.Length.Old = oldLength
+	e.Length.New = newLength
+	return e
+}
+
+// NewPage returns the creation of the article on the german wikipedia
+func NewPage(title string, length int) wikipedia.Event {
+	e := event(wikipedia.TypeNew, title)
+	e.Revision.New = nextRevision()
+	e.Length.New = length
+	return e
+}
+
+// Log returns a log event for the article, e.g. Log("Max Mustermann", wikipedia.LogTypeProtect, "protect")
+func Log(title, logType, logAction string) wikipedia.Event {
+	e := event(wikipedia.TypeLog, title)
+	e.LogType = logType
+	e.LogAction = logAction
+	return e
+}
+
+func event(typ, title string) (e wikipedia.Event) {
+	e.Type = typ
+	e.Title = title
+	e.Wiki = "dewiki"
+	e.User = "Beispielnutzer"
+	e.Timestamp = int(time.Now().Unix())
+	e.Meta.URI = "https://de.wikipedia.org/wiki/" + strings.ReplaceAll(title, " ", "_")
+
+	if strings.HasPrefix(title, "Diskussion:") {
+		e.Namespace = wikipedia.NamespaceTalk
+	}
+
+	return
+}